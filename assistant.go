@@ -0,0 +1,91 @@
+package gosparkclient
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// AssistantBaseURL is the endpoint prefix of Spark assistants
+const AssistantBaseURL = "wss://spark-openapi.cn-huabei-1.xf-yun.com/v1/assistants/"
+
+// AssistantDomain is the domain sent to assistants when none is configured
+const AssistantDomain = "generalv3.5"
+
+// AssistantURL returns the chat endpoint of the assistant with the given ID
+func AssistantURL(assistantID string) string {
+	return AssistantBaseURL + url.PathEscape(assistantID)
+}
+
+// WithAssistant points the client at a configured Spark assistant. Requests
+// use the regular chat frame protocol and signing. Fallback models are not
+// tried for assistants, since they would answer without its prompt and knowledge
+func WithAssistant(assistantID string) ConfigOption {
+	return func(c *Config) {
+		c.HostURL = c.assistantURL(assistantID)
+	}
+}
+
+// WithRequestAssistant sends a single call to the assistant with the given ID
+func WithRequestAssistant(assistantID string) RequestOption {
+	return func(c *Config) {
+		c.HostURL = c.assistantURL(assistantID)
+	}
+}
+
+// WithAssistantBaseURL sets the endpoint prefix of assistants, AssistantBaseURL
+// by default. A host URL that already points at an assistant is moved to the new prefix
+func WithAssistantBaseURL(baseURL string) ConfigOption {
+	return func(c *Config) {
+		if !strings.HasSuffix(baseURL, "/") {
+			baseURL += "/"
+		}
+		if id, ok := strings.CutPrefix(c.HostURL, c.assistantBaseURL()); ok {
+			c.HostURL = baseURL + id
+		}
+		c.AssistantBaseURL = baseURL
+	}
+}
+
+func (c *Config) assistantBaseURL() string {
+	if c.AssistantBaseURL != "" {
+		return c.AssistantBaseURL
+	}
+	return AssistantBaseURL
+}
+
+// assistantURL returns the endpoint of an assistant under the configured prefix
+func (c *Config) assistantURL(assistantID string) string {
+	return c.assistantBaseURL() + url.PathEscape(assistantID)
+}
+
+// isAssistant reports whether hostURL is the endpoint of an assistant
+func (c *Config) isAssistant(hostURL string) bool {
+	return strings.HasPrefix(hostURL, c.assistantBaseURL())
+}
+
+// AssistantClient is a SparkClient bound to a Spark assistant
+type AssistantClient struct {
+	*SparkClient
+	AssistantID string
+}
+
+// NewAssistantClient creates a client for the assistant with the given ID. The
+// domain defaults to AssistantDomain
+func NewAssistantClient(assistantID string, opts ...ConfigOption) (*AssistantClient, error) {
+	if strings.TrimSpace(assistantID) == "" {
+		return nil, newConfigError("invalid configuration", errors.New("assistant ID is required"))
+	}
+
+	opts = append([]ConfigOption{WithAssistant(assistantID)}, opts...)
+	opts = append(opts, func(c *Config) {
+		if c.Domain == "" {
+			c.Domain = AssistantDomain
+		}
+	})
+	client, err := NewSparkClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &AssistantClient{SparkClient: client, AssistantID: assistantID}, nil
+}
@@ -0,0 +1,140 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAssistantURL(t *testing.T) {
+	if got, want := AssistantURL("abc123"), "wss://spark-openapi.cn-huabei-1.xf-yun.com/v1/assistants/abc123"; got != want {
+		t.Errorf("AssistantURL = %q, want %q", got, want)
+	}
+	if got := AssistantURL("a/b"); !strings.HasSuffix(got, "/a%2Fb") {
+		t.Errorf("expected the ID to be escaped, got %q", got)
+	}
+}
+
+func TestNewAssistantClient(t *testing.T) {
+	client, err := NewAssistantClient("abc123", WithCredentials("app", "key", "secret"))
+	if err != nil {
+		t.Fatalf("NewAssistantClient failed: %v", err)
+	}
+	if client.config.HostURL != AssistantURL("abc123") || client.config.Domain != AssistantDomain {
+		t.Errorf("unexpected config %+v", client.config)
+	}
+
+	client, err = NewAssistantClient("abc123", WithCredentials("app", "key", "secret"), WithDomain("4.0Ultra"))
+	if err != nil {
+		t.Fatalf("NewAssistantClient failed: %v", err)
+	}
+	if client.config.Domain != "4.0Ultra" {
+		t.Errorf("domain = %q, want 4.0Ultra", client.config.Domain)
+	}
+
+	client, err = NewAssistantClient("abc123", WithCredentials("app", "key", "secret"), WithAssistantBaseURL("ws://localhost/assistants/"))
+	if err != nil {
+		t.Fatalf("NewAssistantClient failed: %v", err)
+	}
+	if client.config.HostURL != "ws://localhost/assistants/abc123" {
+		t.Errorf("host URL = %q, want it under the configured base", client.config.HostURL)
+	}
+
+	if _, err := NewAssistantClient(" ", WithCredentials("app", "key", "secret")); err == nil {
+		t.Error("expected error for empty assistant ID")
+	}
+}
+
+func TestAssistantClient_Chat(t *testing.T) {
+	var path string
+	var sent SparkAPIRequest
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if r.URL.Query().Get("authorization") == "" {
+			t.Error("assistant request is not signed")
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err := conn.ReadJSON(&sent); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"我是助手"}]}}}`))
+	}))
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server)+"/v1/chat", ""),
+		WithDomain("lite"),
+		WithAssistantBaseURL(wsURL(server)+"/v1/assistants"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	resp, err := client.ChatSimple(context.Background(), "你是谁", WithRequestAssistant("abc123"))
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if path != "/v1/assistants/abc123" {
+		t.Errorf("path = %q", path)
+	}
+	if resp.Payload.Choices.Text[0].Content != "我是助手" || sent.Parameter.Chat.Domain != "lite" {
+		t.Errorf("unexpected exchange: %+v / %+v", resp, sent)
+	}
+}
+
+func TestAssistantClient_NoFallback(t *testing.T) {
+	var fallbackCalls atomic.Int32
+	assistant := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":10012,"message":"internal error"}}`))
+	})
+	defer assistant.Close()
+	healthy := newMockServerFunc(t, func(conn *websocket.Conn) {
+		fallbackCalls.Add(1)
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]}}}`))
+	})
+	defer healthy.Close()
+
+	client, err := NewAssistantClient("abc123",
+		WithCredentials("app", "key", "secret"),
+		WithAssistantBaseURL(wsURL(assistant)+"/v1/assistants/"),
+		WithFallback(DefaultFallbackPolicy(ModelTarget{HostURL: wsURL(healthy), Domain: "lite"})),
+	)
+	if err != nil {
+		t.Fatalf("NewAssistantClient failed: %v", err)
+	}
+
+	_, err = client.ChatSimple(context.Background(), "你好")
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Code != CodeEngineInternalError {
+		t.Errorf("expected the assistant's error, got %v", err)
+	}
+	if n := fallbackCalls.Load(); n != 0 {
+		t.Errorf("assistant call fell back %d times", n)
+	}
+
+	// Regular calls from the same client still fall back
+	if _, err := client.ChatSimple(context.Background(), "你好", WithRequestHostURL(wsURL(assistant)+"/v1/chat")); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if n := fallbackCalls.Load(); n != 1 {
+		t.Errorf("expected 1 fallback call, got %d", n)
+	}
+}
@@ -0,0 +1,203 @@
+package gosparkclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BudgetPeriod is the window a budget applies to
+type BudgetPeriod int
+
+const (
+	BudgetDaily BudgetPeriod = iota
+	BudgetMonthly
+)
+
+// start returns the beginning of the period containing t
+func (p BudgetPeriod) start(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	if p == BudgetMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// BudgetPolicy limits the tokens or cost a tenant may consume per period.
+// A policy with an empty Tenant applies separately to every tenant that has
+// no policy of its own; calls without a tenant are checked against the usage
+// of all tenants combined. Zero limits are not enforced
+type BudgetPolicy struct {
+	Tenant    string
+	Period    BudgetPeriod
+	MaxTokens int
+	MaxCost   float64
+}
+
+// BudgetEnforcer rejects or cancels chat calls that would take a tenant over
+// its budget. Consumption is read from, and recorded in, a UsageLedger
+type BudgetEnforcer struct {
+	ledger   *UsageLedger
+	policies []BudgetPolicy
+}
+
+// NewBudgetEnforcer creates an enforcer for policies backed by ledger
+func NewBudgetEnforcer(ledger *UsageLedger, policies ...BudgetPolicy) *BudgetEnforcer {
+	return &BudgetEnforcer{ledger: ledger, policies: policies}
+}
+
+// budgetState is a policy together with what has been spent against it
+type budgetState struct {
+	policy      BudgetPolicy
+	spentTokens int
+	spentCost   float64
+}
+
+// exceeded reports whether spending prompt and completion more tokens on domain breaks the budget
+func (s budgetState) exceeded(prices PriceTable, domain string, prompt, completion int) bool {
+	if s.policy.MaxTokens > 0 && s.spentTokens+prompt+completion > s.policy.MaxTokens {
+		return true
+	}
+	if s.policy.MaxCost > 0 {
+		cost := prices.Cost(domain, SparkUsage{PromptTokens: prompt, CompletionTokens: completion})
+		if s.spentCost+cost > s.policy.MaxCost {
+			return true
+		}
+	}
+	return false
+}
+
+// load returns the policies that apply to tenant with their current spending
+func (e *BudgetEnforcer) load(ctx context.Context, tenant string) ([]budgetState, error) {
+	var applicable []BudgetPolicy
+	for _, p := range e.policies {
+		if p.Tenant == tenant && tenant != "" {
+			applicable = append(applicable, p)
+		}
+	}
+	if len(applicable) == 0 {
+		for _, p := range e.policies {
+			if p.Tenant == "" {
+				applicable = append(applicable, p)
+			}
+		}
+	}
+
+	now := e.ledger.now()
+	states := make([]budgetState, 0, len(applicable))
+	for _, p := range applicable {
+		records, err := e.ledger.store.Query(ctx, UsageFilter{
+			Tenant: tenant,
+			From:   p.Period.start(now, e.ledger.location),
+		})
+		if err != nil {
+			return nil, err
+		}
+		state := budgetState{policy: p}
+		for _, rec := range records {
+			state.spentTokens += rec.TotalTokens
+			state.spentCost += rec.Cost
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// anyExceeded reports whether any of states would be exceeded
+func (e *BudgetEnforcer) anyExceeded(states []budgetState, domain string, prompt, completion int) bool {
+	for _, s := range states {
+		if s.exceeded(e.ledger.prices, domain, prompt, completion) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware returns middleware that enforces the budgets and records usage in
+// the ledger. It replaces the ledger's own middleware
+func (e *BudgetEnforcer) Middleware() Middleware {
+	record := e.ledger.Middleware()
+
+	return func(next Handler) Handler {
+		next = record(next)
+
+		return func(ctx context.Context, call *Call) (*Result, error) {
+			if call.Kind == CallEmbedding {
+				return next(ctx, call)
+			}
+
+			tenant := TenantFromContext(ctx)
+			states, err := e.load(ctx, tenant)
+			if err != nil {
+				return nil, newRequestError("failed to load budget usage", err)
+			}
+			if len(states) == 0 {
+				return next(ctx, call)
+			}
+
+			estimator := call.Estimator
+			if estimator == nil {
+				estimator = NewTokenEstimator()
+			}
+			domain := call.Model.Domain
+			prompt := estimator.EstimateTokens(call.Request)
+			if e.anyExceeded(states, domain, prompt, 0) {
+				return nil, newBudgetError(fmt.Sprintf("tenant %q has insufficient budget for an estimated %d prompt tokens", tenant, prompt), nil)
+			}
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			var uid, answer string
+			completion := 0
+			overBudget := false
+
+			onRequest := call.OnRequest
+			call.OnRequest = func(apiReq *SparkAPIRequest) {
+				domain = apiReq.Parameter.Chat.Domain
+				uid = apiReq.Header.UID
+				if onRequest != nil {
+					onRequest(apiReq)
+				}
+			}
+
+			onFrame := call.OnFrame
+			call.OnFrame = func(resp *SparkAPIResponse) {
+				if overBudget {
+					return
+				}
+				if len(resp.Payload.Choices.Text) > 0 {
+					answer += resp.Payload.Choices.Text[0].Content
+					completion = estimator.EstimateText(answer)
+				}
+				if resp.Payload.Choices.Status != 2 && e.anyExceeded(states, domain, prompt, completion) {
+					overBudget = true
+					cancel()
+					return
+				}
+				if onFrame != nil {
+					onFrame(resp)
+				}
+			}
+
+			result, err := next(ctx, call)
+			if !overBudget {
+				return result, err
+			}
+
+			// The tokens were consumed even though no usage was reported
+			recErr := e.ledger.Record(context.WithoutCancel(ctx), UsageRecord{
+				Tenant:           tenant,
+				UID:              uid,
+				Domain:           domain,
+				PromptTokens:     prompt,
+				CompletionTokens: completion,
+				TotalTokens:      prompt + completion,
+			})
+			if recErr != nil && e.ledger.onError != nil {
+				e.ledger.onError(recErr)
+			}
+			return nil, newBudgetError(fmt.Sprintf("tenant %q exceeded its budget while streaming", tenant), err)
+		}
+	}
+}
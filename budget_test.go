@@ -0,0 +1,88 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBudgetEnforcer_RejectsBeforeSending(t *testing.T) {
+	dialed := false
+	server := newMockServerFunc(t, func(conn *websocket.Conn) { dialed = true })
+	defer server.Close()
+
+	ledger := NewUsageLedger(NewMemoryUsageStore())
+	ledger.Record(context.Background(), UsageRecord{Tenant: "acme", TotalTokens: 95})
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+		WithBudget(NewBudgetEnforcer(ledger, BudgetPolicy{Tenant: "acme", Period: BudgetDaily, MaxTokens: 100})),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	_, err = client.ChatSimple(WithTenant(context.Background(), "acme"), "please write a long essay about the history of the world")
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrBudgetExceeded {
+		t.Fatalf("expected budget error, got %v", err)
+	}
+	if dialed {
+		t.Error("request should not have been sent")
+	}
+
+	// Other tenants have no policy and are unaffected
+	if states, _ := NewBudgetEnforcer(ledger, BudgetPolicy{Tenant: "acme", MaxTokens: 100}).load(context.Background(), "other"); len(states) != 0 {
+		t.Errorf("expected no policies for other tenant, got %d", len(states))
+	}
+}
+
+func TestBudgetEnforcer_CancelsMidStream(t *testing.T) {
+	frame := `{"header":{"code":0},"payload":{"choices":{"status":1,"text":[{"content":"` + strings.Repeat("word ", 20) + `"}]}}}`
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		for i := 0; i < 50; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+				return
+			}
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":""}]}}}`))
+	})
+	defer server.Close()
+
+	store := NewMemoryUsageStore()
+	ledger := NewUsageLedger(store)
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+		WithDomain("lite"),
+		WithBudget(NewBudgetEnforcer(ledger, BudgetPolicy{Period: BudgetMonthly, MaxTokens: 60})),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	frames := 0
+	err = client.ChatWithCallback(WithTenant(context.Background(), "acme"), &SparkChatRequest{
+		Messages: []SparkMessage{{Role: "user", Content: "hi"}},
+	}, func(resp *SparkAPIResponse) { frames++ })
+
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrBudgetExceeded {
+		t.Fatalf("expected budget error, got %v", err)
+	}
+	if frames != 2 {
+		t.Errorf("expected 2 frames within budget, got %d", frames)
+	}
+
+	records, _ := store.Query(context.Background(), UsageFilter{Tenant: "acme"})
+	if len(records) != 1 || records[0].CompletionTokens == 0 {
+		t.Errorf("expected estimated usage to be recorded, got %+v", records)
+	}
+}
@@ -0,0 +1,416 @@
+package gosparkclient
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache stores encoded call results. A zero ttl means the entry does not expire
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// cacheEntry is a cached value with its expiry time
+type cacheEntry struct {
+	Value   []byte    `json:"value"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (e cacheEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+func newCacheEntry(value []byte, ttl time.Duration, now time.Time) cacheEntry {
+	entry := cacheEntry{Value: value}
+	if ttl > 0 {
+		entry.Expires = now.Add(ttl)
+	}
+	return entry
+}
+
+// LRUCache is an in-memory Cache that evicts the least recently used entry once full
+type LRUCache struct {
+	capacity int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry cacheEntry
+}
+
+// NewLRUCache creates an LRUCache holding at most capacity entries
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		now:      time.Now,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Get returns the value stored under key
+func (c *LRUCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	item := el.Value.(*lruItem)
+	if item.entry.expired(c.now()) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return item.entry.Value, true, nil
+}
+
+// Set stores value under key
+func (c *LRUCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := newCacheEntry(value, ttl, c.now())
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*lruItem).entry = entry
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(&lruItem{key: key, entry: entry})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruItem).key)
+	}
+	return nil
+}
+
+// Delete removes the value stored under key
+func (c *LRUCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+// Len returns the number of stored entries, including expired ones not yet evicted
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// DiskCache is a Cache storing one file per entry in a directory
+type DiskCache struct {
+	dir string
+	now func() time.Time
+}
+
+// NewDiskCache creates a DiskCache in dir, creating the directory if needed
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir, now: time.Now}, nil
+}
+
+// path returns the file that stores key
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get returns the value stored under key. Expired entries are removed
+func (c *DiskCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, err
+	}
+	if entry.expired(c.now()) {
+		os.Remove(path)
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+// Set stores value under key. The file is written atomically
+func (c *DiskCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	data, err := json.Marshal(newCacheEntry(value, ttl, c.now()))
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+// Delete removes the value stored under key
+func (c *DiskCache) Delete(ctx context.Context, key string) error {
+	err := os.Remove(c.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// CacheOption configures the cache middleware
+type CacheOption func(*cacheOptions)
+
+type cacheOptions struct {
+	ttl     time.Duration
+	onError func(error)
+}
+
+// WithCacheTTL sets how long cached results stay valid. Zero, the default, keeps them until evicted
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithCacheErrorHandler sets a function called when the cache cannot be read or written.
+// Cache errors never fail the call itself
+func WithCacheErrorHandler(handler func(error)) CacheOption {
+	return func(o *cacheOptions) {
+		o.onError = handler
+	}
+}
+
+// cachedChat is the cached form of a chat result
+type cachedChat struct {
+	// Frames holds the content of each streamed frame, replayed through OnFrame on a hit
+	Frames    []string          `json:"frames"`
+	Response  *SparkAPIResponse `json:"response"`
+	Model     ModelTarget       `json:"model"`
+	Citations []Citation        `json:"citations,omitempty"`
+}
+
+// newCachedChat captures a chat result for caching
+func newCachedChat(frames []string, resp *SparkAPIResponse) cachedChat {
+	return cachedChat{Frames: frames, Response: resp, Model: resp.Model, Citations: resp.Citations}
+}
+
+// chatCacheKey identifies the normalized parts of a chat request that determine its answer
+type chatCacheKey struct {
+	Kind         string            `json:"kind"`
+	HostURL      string            `json:"host_url"`
+	Domain       string            `json:"domain"`
+	PatchIDs     []string          `json:"patch_ids,omitempty"`
+	UID          string            `json:"uid"`
+	Auditing     string            `json:"auditing,omitempty"`
+	Temperature  float64           `json:"temperature"`
+	TopK         int               `json:"top_k"`
+	MaxTokens    int               `json:"max_tokens"`
+	QuestionType string            `json:"question_type"`
+	System       string            `json:"system"`
+	Messages     []SparkMessage    `json:"messages"`
+	Functions    json.RawMessage   `json:"functions,omitempty"`
+	WebSearch    *WebSearchOptions `json:"web_search,omitempty"`
+
+	TopP             float64         `json:"top_p,omitempty"`
+	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
+	ChatID           string          `json:"chat_id,omitempty"`
+	Extra            map[string]any  `json:"extra,omitempty"`
+}
+
+// CacheKey returns the cache key of a call. Chat keys cover the model and its patches, user,
+// auditing mode, parameters and messages; embedding keys cover the text,
+// domain and user
+func CacheKey(call *Call) string {
+	var key any
+	if call.Kind == CallEmbedding {
+		key = struct {
+			Kind   string `json:"kind"`
+			Domain string `json:"domain"`
+			UID    string `json:"uid"`
+			Text   string `json:"text"`
+		}{"embedding", call.EmbeddingDomain, call.UID, call.Query}
+	} else {
+		req := call.Request
+		if req == nil {
+			req = &SparkChatRequest{}
+		}
+		key = chatCacheKey{
+			Kind:         "chat",
+			HostURL:      call.Model.HostURL,
+			Domain:       call.Model.Domain,
+			PatchIDs:     call.PatchIDs,
+			UID:          call.UID,
+			Auditing:     call.Auditing,
+			Temperature:  req.Temperature,
+			TopK:         req.TopK,
+			MaxTokens:    req.MaxTokens,
+			QuestionType: req.QuestionType,
+			System:       req.System,
+			Messages:     req.Messages,
+			Functions:    req.Functions,
+			WebSearch:    req.WebSearch,
+
+			TopP:             req.TopP,
+			PresencePenalty:  req.PresencePenalty,
+			FrequencyPenalty: req.FrequencyPenalty,
+			Stop:             req.Stop,
+			ResponseFormat:   req.ResponseFormat,
+			ChatID:           req.ChatID,
+			Extra:            req.Extra,
+		}
+	}
+	data, _ := json.Marshal(key)
+	sum := sha256.Sum256(data)
+	return "spark:" + hex.EncodeToString(sum[:])
+}
+
+// CacheMiddleware returns middleware that serves repeated chat and embedding
+// calls from cache. Cached chats are replayed to OnFrame as synthetic frames
+func CacheMiddleware(cache Cache, opts ...CacheOption) Middleware {
+	o := &cacheOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	report := func(err error) {
+		if err != nil && o.onError != nil {
+			o.onError(err)
+		}
+	}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*Result, error) {
+			key := CacheKey(call)
+
+			data, ok, err := cache.Get(ctx, key)
+			report(err)
+			if ok {
+				result, err := replayCached(call, data)
+				if err == nil {
+					return result, nil
+				}
+				report(err)
+			}
+
+			var frames []string
+			if call.Kind != CallEmbedding {
+				onFrame := call.OnFrame
+				call.OnFrame = func(resp *SparkAPIResponse) {
+					content := ""
+					if len(resp.Payload.Choices.Text) > 0 {
+						content = resp.Payload.Choices.Text[0].Content
+					}
+					frames = append(frames, content)
+					if onFrame != nil {
+						onFrame(resp)
+					}
+				}
+			}
+
+			result, err := next(ctx, call)
+			if err != nil || result == nil {
+				return result, err
+			}
+
+			var value any = result.Embedding
+			if call.Kind != CallEmbedding {
+				if result.Response == nil {
+					return result, nil
+				}
+				value = newCachedChat(frames, result.Response)
+			}
+			data, err = json.Marshal(value)
+			if err == nil {
+				err = cache.Set(ctx, key, data, o.ttl)
+			}
+			report(err)
+			return result, nil
+		}
+	}
+}
+
+// replayCached decodes a cached result, replaying chat frames to call.OnFrame
+func replayCached(call *Call, data []byte) (*Result, error) {
+	if call.Kind == CallEmbedding {
+		var emb SparkAPIEmbResponse
+		if err := json.Unmarshal(data, &emb); err != nil {
+			return nil, err
+		}
+		return &Result{Embedding: &emb}, nil
+	}
+
+	var cached cachedChat
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, err
+	}
+	if cached.Response == nil {
+		return nil, errors.New("cached chat has no response")
+	}
+	return replayChat(call, cached), nil
+}
+
+// replayChat replays a cached chat to call.OnFrame as synthetic frames
+func replayChat(call *Call, cached cachedChat) *Result {
+	response := *cached.Response
+	response.Model = cached.Model
+	response.Citations = cached.Citations
+	response.Payload.Choices.Text = append([]SparkChoice(nil), response.Payload.Choices.Text...)
+
+	if call.OnFrame != nil {
+		for i, content := range cached.Frames {
+			frame := response
+			frame.Payload.Choices.Seq = i
+			choice := SparkChoice{Role: "assistant"}
+			if len(response.Payload.Choices.Text) > 0 {
+				choice = response.Payload.Choices.Text[0]
+			}
+			choice.Content = content
+			frame.Payload.Choices.Text = []SparkChoice{choice}
+			if i < len(cached.Frames)-1 {
+				frame.Payload.Choices.Status = 1
+				frame.Payload.Usage.Text = SparkUsage{}
+			}
+			call.OnFrame(&frame)
+		}
+	}
+	return &Result{Response: &response}
+}
@@ -0,0 +1,226 @@
+package gosparkclient
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewLRUCache(2)
+	cache.now = func() time.Time { return now }
+
+	cache.Set(ctx, "a", []byte("1"), 0)
+	cache.Set(ctx, "b", []byte("2"), time.Minute)
+	cache.Get(ctx, "a") // a is now more recent than b
+	cache.Set(ctx, "c", []byte("3"), 0)
+
+	if _, ok, _ := cache.Get(ctx, "b"); ok {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	if v, ok, _ := cache.Get(ctx, "a"); !ok || string(v) != "1" {
+		t.Errorf("Get(a) = %q, %v", v, ok)
+	}
+
+	cache.Set(ctx, "d", []byte("4"), time.Minute)
+	now = now.Add(time.Minute)
+	if _, ok, _ := cache.Get(ctx, "d"); ok {
+		t.Error("expected expired entry to be missed")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	ctx := context.Background()
+	cache, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskCache failed: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	if err := cache.Set(ctx, "key", []byte(`{"x":1}`), time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if v, ok, err := cache.Get(ctx, "key"); err != nil || !ok || string(v) != `{"x":1}` {
+		t.Errorf("Get = %q, %v, %v", v, ok, err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok, _ := cache.Get(ctx, "key"); ok {
+		t.Error("expected expired entry to be missed")
+	}
+	if err := cache.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete of missing key failed: %v", err)
+	}
+}
+
+func TestSparkClient_Cache(t *testing.T) {
+	var dials atomic.Int32
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		dials.Add(1)
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0,"sid":"s1"},"payload":{"choices":{"status":0,"text":[{"content":"你","role":"assistant"}]}}}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0,"sid":"s1"},"payload":{"choices":{"status":2,"text":[{"content":"好","role":"assistant"}]},"usage":{"text":{"total_tokens":3}}}}`))
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+		WithCache(NewLRUCache(10)),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx := context.Background()
+	req := &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: "你好"}}}
+	first, err := client.Chat(ctx, req)
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	var frames []*SparkAPIResponse
+	if err := client.ChatWithCallback(ctx, req, func(resp *SparkAPIResponse) {
+		frames = append(frames, resp)
+	}); err != nil {
+		t.Fatalf("ChatWithCallback failed: %v", err)
+	}
+	if n := dials.Load(); n != 1 {
+		t.Errorf("expected 1 upstream call, got %d", n)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 replayed frames, got %d", len(frames))
+	}
+	if frames[0].Payload.Choices.Text[0].Content != "你" || frames[0].Payload.Choices.Status != 1 {
+		t.Errorf("unexpected first frame: %+v", frames[0].Payload.Choices)
+	}
+	last := frames[1]
+	if last.Payload.Choices.Text[0].Content != "好" || last.Payload.Choices.Status != 2 || last.Payload.Usage.Text.TotalTokens != 3 {
+		t.Errorf("unexpected last frame: %+v", last.Payload)
+	}
+
+	cached, err := client.Chat(ctx, req)
+	if err != nil {
+		t.Fatalf("cached Chat failed: %v", err)
+	}
+	if cached.Payload.Choices.Text[0].Content != first.Payload.Choices.Text[0].Content || cached.Model != first.Model {
+		t.Errorf("cached response %+v differs from %+v", cached, first)
+	}
+
+	other := &SparkChatRequest{Messages: req.Messages, Temperature: 0.9}
+	if _, err := client.Chat(ctx, other); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if n := dials.Load(); n != 2 {
+		t.Errorf("expected different parameters to miss the cache, got %d upstream calls", n)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	base := func() *Call {
+		return &Call{
+			Kind:    CallChat,
+			Request: &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: "你好"}}},
+			Model:   ModelTarget{HostURL: "wss://a", Domain: "lite"},
+		}
+	}
+	key := CacheKey(base())
+	if CacheKey(base()) != key {
+		t.Fatal("identical calls must have the same key")
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Call)
+	}{
+		{"chat ID", func(c *Call) { c.Request.ChatID = "session-2" }},
+		{"domain", func(c *Call) { c.Model.Domain = "4.0Ultra" }},
+		{"host URL", func(c *Call) { c.Model.HostURL = "wss://b" }},
+		{"patch IDs", func(c *Call) { c.PatchIDs = []string{"res-1"} }},
+		{"UID", func(c *Call) { c.UID = "user-2" }},
+		{"auditing", func(c *Call) { c.Auditing = "strict" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := base()
+			tt.change(call)
+			if CacheKey(call) == key {
+				t.Errorf("calls differing by %s share a cache key", tt.name)
+			}
+		})
+	}
+}
+
+func TestSparkClient_CacheSeparatesUsers(t *testing.T) {
+	var dials atomic.Int32
+	both := make(chan struct{})
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if dials.Add(1) == 2 {
+			close(both)
+		}
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		// Hold the first call open so the second one could join it
+		select {
+		case <-both:
+		case <-time.After(time.Second):
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"`+req.Header.UID+`"}]}}}`))
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+		WithCache(NewLRUCache(10)),
+		WithSingleflight(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx := context.Background()
+	req := &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: "我是谁?"}}}
+	users := []string{"user-1", "user-2"}
+	check := func() {
+		t.Helper()
+		var wg sync.WaitGroup
+		for _, uid := range users {
+			wg.Add(1)
+			go func(uid string) {
+				defer wg.Done()
+				resp, err := client.Chat(ctx, req, WithRequestUID(uid))
+				if err != nil {
+					t.Errorf("Chat failed: %v", err)
+					return
+				}
+				if got := resp.Payload.Choices.Text[0].Content; got != uid {
+					t.Errorf("%s got the answer for %s", uid, got)
+				}
+			}(uid)
+		}
+		wg.Wait()
+	}
+
+	check()
+	if n := dials.Load(); n != 2 {
+		t.Errorf("expected 2 upstream calls, got %d", n)
+	}
+
+	// Each user's answer is now cached separately
+	check()
+	if n := dials.Load(); n != 2 {
+		t.Errorf("expected cached answers, got %d upstream calls", n)
+	}
+}
@@ -0,0 +1,499 @@
+package gosparkclient
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ChatDocBaseURL is the base URL of the document Q&A API
+const ChatDocBaseURL = "https://chatdoc.xfyun.cn/openapi"
+
+// DocumentStatus is the processing stage of an uploaded document
+type DocumentStatus string
+
+// Document processing stages, in the order they are reached
+const (
+	DocumentUploaded  DocumentStatus = "uploaded"
+	DocumentTexted    DocumentStatus = "texted"
+	DocumentOCRing    DocumentStatus = "ocring"
+	DocumentSplitting DocumentStatus = "spliting"
+	DocumentVectoring DocumentStatus = "vectoring"
+	DocumentVectored  DocumentStatus = "vectored"
+	DocumentFailed    DocumentStatus = "failed"
+)
+
+// Ready reports whether the document is indexed and can be asked about
+func (s DocumentStatus) Ready() bool {
+	return s == DocumentVectored
+}
+
+// Done reports whether processing has finished, successfully or not
+func (s DocumentStatus) Done() bool {
+	return s == DocumentVectored || s == DocumentFailed
+}
+
+// Document is a file uploaded to the document Q&A service
+type Document struct {
+	FileID     string         `json:"fileId"`
+	FileName   string         `json:"fileName,omitempty"`
+	FileType   string         `json:"fileType,omitempty"`
+	FileSize   int64          `json:"fileSize,omitempty"`
+	FileStatus DocumentStatus `json:"fileStatus"`
+}
+
+// DocumentPage is a page of ListDocuments results
+type DocumentPage struct {
+	Documents []Document `json:"rows"`
+	Total     int        `json:"total"`
+}
+
+// DocumentQuestion is a question asked about a set of uploaded documents
+type DocumentQuestion struct {
+	FileIDs  []string       `json:"fileIds"`
+	Messages []SparkMessage `json:"messages"`
+	// Options tunes retrieval and generation, leaving the service defaults when nil
+	Options *DocumentChatOptions `json:"chatExtends,omitempty"`
+}
+
+// DocumentChatOptions tunes how answers are retrieved and generated
+type DocumentChatOptions struct {
+	// PromptTemplate replaces the service's prompt. It may reference
+	// {{question}} and {{content}}, the retrieved paragraphs
+	PromptTemplate string `json:"wikiPromptTpl,omitempty"`
+	// FilterScore is the minimum relevance of paragraphs used in the answer
+	FilterScore float64 `json:"wikiFilterScore,omitempty"`
+	Temperature float64 `json:"temperature,omitempty"`
+}
+
+// DocumentAnswerFrame is a single frame of a streamed document answer
+type DocumentAnswerFrame struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	SID     string `json:"sid"`
+	Content string `json:"content"`
+	Status  int    `json:"status"`
+	// FileRefer maps file IDs to the paragraphs the answer is based on
+	FileRefer string `json:"fileRefer,omitempty"`
+}
+
+// DocumentReference is a document an answer is based on
+type DocumentReference struct {
+	FileID     string
+	Paragraphs []int
+}
+
+// DocumentAnswer is the complete answer to a DocumentQuestion
+type DocumentAnswer struct {
+	Content    string
+	SID        string
+	References []DocumentReference
+}
+
+// chatDocResponse is the envelope of all document Q&A HTTP responses
+type chatDocResponse struct {
+	Flag bool            `json:"flag"`
+	Code int             `json:"code"`
+	Desc string          `json:"desc"`
+	SID  string          `json:"sid"`
+	Data json.RawMessage `json:"data"`
+}
+
+// UploadDocument uploads the file read from r and returns its file ID.
+// The document can be asked about once its status is DocumentVectored
+func (c *SparkClient) UploadDocument(ctx context.Context, fileName string, r io.Reader) (string, error) {
+	if fileName == "" {
+		return "", newRequestError("file name is required", nil)
+	}
+
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		err := writeDocumentForm(form, fileName, r)
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	var data struct {
+		FileID string `json:"fileId"`
+	}
+	if err := c.chatDocPost(ctx, "/v1/file/upload", form.FormDataContentType(), pr, &data); err != nil {
+		return "", err
+	}
+	return data.FileID, nil
+}
+
+// writeDocumentForm writes the multipart body of an upload and closes it
+func writeDocumentForm(form *multipart.Writer, fileName string, r io.Reader) error {
+	if err := form.WriteField("fileName", fileName); err != nil {
+		return err
+	}
+	if err := form.WriteField("fileType", "wiki"); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// UploadDocumentURL has the service download the document at fileURL and returns its file ID
+func (c *SparkClient) UploadDocumentURL(ctx context.Context, fileName, fileURL string) (string, error) {
+	if fileName == "" || fileURL == "" {
+		return "", newRequestError("file name and URL are required", nil)
+	}
+
+	var body strings.Builder
+	form := multipart.NewWriter(&body)
+	fields := [][2]string{{"fileName", fileName}, {"fileType", "wiki"}, {"url", fileURL}}
+	for _, field := range fields {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return "", newRequestError("failed to encode upload form", err)
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", newRequestError("failed to encode upload form", err)
+	}
+
+	var data struct {
+		FileID string `json:"fileId"`
+	}
+	if err := c.chatDocPost(ctx, "/v1/file/upload", form.FormDataContentType(), strings.NewReader(body.String()), &data); err != nil {
+		return "", err
+	}
+	return data.FileID, nil
+}
+
+// DocumentStatuses returns the processing status of the given documents
+func (c *SparkClient) DocumentStatuses(ctx context.Context, fileIDs ...string) ([]Document, error) {
+	if len(fileIDs) == 0 {
+		return nil, newRequestError("at least one file ID is required", nil)
+	}
+	var docs []Document
+	if err := c.chatDocPostForm(ctx, "/v1/file/status", url.Values{"fileIds": {strings.Join(fileIDs, ",")}}, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// WaitForDocuments polls the status of the given documents every interval
+// until all of them are done. An error is returned if any of them failed or
+// is missing from the status response
+func (c *SparkClient) WaitForDocuments(ctx context.Context, interval time.Duration, fileIDs ...string) ([]Document, error) {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		docs, err := c.DocumentStatuses(ctx, fileIDs...)
+		if err != nil {
+			return nil, err
+		}
+
+		reported := make(map[string]bool, len(docs))
+		for _, doc := range docs {
+			reported[doc.FileID] = true
+		}
+		var missing []string
+		for _, id := range fileIDs {
+			if !reported[id] {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			return docs, newResponseError("no status reported for documents: "+strings.Join(missing, ", "), nil)
+		}
+
+		done := true
+		var failed []string
+		for _, doc := range docs {
+			if !doc.FileStatus.Done() {
+				done = false
+			}
+			if doc.FileStatus == DocumentFailed {
+				failed = append(failed, doc.FileID)
+			}
+		}
+		if done {
+			if len(failed) > 0 {
+				return docs, newResponseError("document processing failed: "+strings.Join(failed, ", "), nil)
+			}
+			return docs, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return docs, newRequestError("request cancelled", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListDocuments returns a page of the uploaded documents. Pages are numbered from 1
+func (c *SparkClient) ListDocuments(ctx context.Context, page, pageSize int) (*DocumentPage, error) {
+	if page < 1 || pageSize < 1 {
+		return nil, newRequestError(fmt.Sprintf("invalid page %d of size %d", page, pageSize), nil)
+	}
+	form := url.Values{
+		"pageNo":   {strconv.Itoa(page)},
+		"pageSize": {strconv.Itoa(pageSize)},
+	}
+	var result DocumentPage
+	if err := c.chatDocPostForm(ctx, "/v1/file/list", form, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DeleteDocuments deletes the given documents
+func (c *SparkClient) DeleteDocuments(ctx context.Context, fileIDs ...string) error {
+	if len(fileIDs) == 0 {
+		return newRequestError("at least one file ID is required", nil)
+	}
+	return c.chatDocPostForm(ctx, "/v1/file/del", url.Values{"fileIds": {strings.Join(fileIDs, ",")}}, nil)
+}
+
+// AskDocuments answers q from the documents it names. Frames are passed to
+// onFrame, if not nil, as they arrive
+func (c *SparkClient) AskDocuments(ctx context.Context, q *DocumentQuestion, onFrame func(*DocumentAnswerFrame)) (_ *DocumentAnswer, err error) {
+	if q == nil || len(q.FileIDs) == 0 {
+		return nil, newRequestError("at least one file ID is required", nil)
+	}
+	if len(q.Messages) == 0 {
+		return nil, newRequestError("at least one message is required", nil)
+	}
+
+	start := time.Now()
+	answer := &DocumentAnswer{}
+	ctx, meter := c.newMeter(ctx, CallDocumentChat, "")
+	defer func() {
+		c.logOutcome(ctx, CallDocumentChat, "", answer.SID, start, nil, err)
+		meter.finish(nil, err)
+	}()
+
+	auth, creds, err := c.chatDocAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.reportCredentials(creds, nil, err) }()
+
+	chatURL, err := c.chatDocWebSocketURL()
+	if err != nil {
+		return nil, err
+	}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: c.config.Timeout,
+		NetDialContext:   c.transport.DialContext,
+		Proxy:            c.transport.Proxy,
+	}
+	trace := ContextClientTrace(ctx)
+	trace.dialStart(chatURL)
+	conn, resp, err := dialer.DialContext(ctx, chatURL+"?"+auth.Encode(), nil)
+	if err != nil {
+		var sparkErr *SparkError
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			sparkErr = newAuthError("handshake rejected: "+readBody(resp), err)
+		} else {
+			sparkErr = newConnectionError("failed to establish WebSocket connection", err)
+		}
+		trace.dialDone(chatURL, sparkErr)
+		return nil, sparkErr
+	}
+	trace.dialDone(chatURL, nil)
+	defer conn.Close()
+
+	stopClose := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopClose()
+
+	if err := conn.WriteJSON(q); err != nil {
+		return nil, newRequestError("failed to send message", err)
+	}
+	trace.requestSent()
+	c.logger.DebugContext(ctx, "spark request sent",
+		"kind", CallDocumentChat,
+		"files", len(q.FileIDs),
+		"messages", len(q.Messages),
+		"content", c.logContent(lastMessageContent(q.Messages)),
+	)
+
+	var content strings.Builder
+	for first := true; ; first = false {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, newRequestError("request cancelled", ctx.Err())
+			}
+			return nil, newWebSocketError("failed to read message", err)
+		}
+		if first {
+			trace.firstFrame()
+		}
+		meter.frame()
+
+		var frame DocumentAnswerFrame
+		if err := json.Unmarshal(msg, &frame); err != nil {
+			return nil, newResponseError("failed to parse response", err)
+		}
+		if frame.SID != "" {
+			answer.SID = frame.SID
+		}
+		if frame.Code != 0 {
+			return nil, newHeaderError(SparkHeader{Code: frame.Code, Message: frame.Message, SID: frame.SID})
+		}
+
+		content.WriteString(frame.Content)
+		if frame.FileRefer != "" {
+			refs, err := parseFileRefer(frame.FileRefer)
+			if err != nil {
+				return nil, newResponseError("failed to parse document references", err)
+			}
+			answer.References = refs
+		}
+		if onFrame != nil {
+			onFrame(&frame)
+		}
+
+		if frame.Status == 2 {
+			answer.Content = content.String()
+			return answer, nil
+		}
+	}
+}
+
+// parseFileRefer decodes the fileRefer field of an answer frame, a JSON
+// object mapping file IDs to paragraph indexes
+func parseFileRefer(fileRefer string) ([]DocumentReference, error) {
+	var refer map[string][]int
+	if err := json.Unmarshal([]byte(fileRefer), &refer); err != nil {
+		return nil, err
+	}
+	refs := make([]DocumentReference, 0, len(refer))
+	for fileID, paragraphs := range refer {
+		refs = append(refs, DocumentReference{FileID: fileID, Paragraphs: paragraphs})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].FileID < refs[j].FileID })
+	return refs, nil
+}
+
+// chatDocPostForm posts form to the document Q&A API and decodes the response data into out
+func (c *SparkClient) chatDocPostForm(ctx context.Context, path string, form url.Values, out any) error {
+	return c.chatDocPost(ctx, path, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), out)
+}
+
+// chatDocPost sends a signed POST to the document Q&A API and decodes the
+// response data into out, which may be nil
+func (c *SparkClient) chatDocPost(ctx context.Context, path, contentType string, body io.Reader, out any) (err error) {
+	auth, creds, err := c.chatDocAuth(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { c.reportCredentials(creds, nil, err) }()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.chatDocBaseURL()+path, body)
+	if err != nil {
+		return newRequestError("failed to create document request", err)
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	for key := range auth {
+		httpReq.Header.Set(key, auth.Get(key))
+	}
+
+	c.logger.DebugContext(ctx, "spark document request", "path", path, "app_id", creds.AppID)
+	httpClient := &http.Client{Transport: c.transport, Timeout: c.config.Timeout}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return newRequestError("request cancelled", ctx.Err())
+		}
+		return newConnectionError("failed to send document request", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return newAuthError("request rejected: "+readBody(resp), nil)
+	}
+	defer resp.Body.Close()
+
+	var response chatDocResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return newResponseError(fmt.Sprintf("failed to parse document response (HTTP %d)", resp.StatusCode), err)
+	}
+	if response.Code != 0 {
+		return newHeaderError(SparkHeader{Code: response.Code, Message: response.Desc, SID: response.SID})
+	}
+	if out == nil || len(response.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(response.Data, out); err != nil {
+		return newResponseError("failed to parse document response data", err)
+	}
+	return nil
+}
+
+// chatDocAuth returns the appId, timestamp and signature parameters that
+// authenticate a document Q&A request. Unlike the chat API the service
+// signs with the APISecret directly, so a custom Signer is not used
+func (c *SparkClient) chatDocAuth(ctx context.Context) (url.Values, Credentials, error) {
+	creds, err := c.retrieveCredentials(ctx)
+	if err != nil {
+		return nil, Credentials{}, err
+	}
+	if creds.AppID == "" || creds.APISecret == "" {
+		return nil, Credentials{}, newAuthError("document Q&A requires an AppID and APISecret", nil)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	return url.Values{
+		"appId":     {creds.AppID},
+		"timestamp": {ts},
+		"signature": {chatDocSignature(creds.AppID, creds.APISecret, ts)},
+	}, creds, nil
+}
+
+// chatDocSignature computes base64(hmac-sha1(md5hex(appID+ts), apiSecret))
+func chatDocSignature(appID, apiSecret, ts string) string {
+	sum := md5.Sum([]byte(appID + ts))
+	mac := hmac.New(sha1.New, []byte(apiSecret))
+	mac.Write([]byte(hex.EncodeToString(sum[:])))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *SparkClient) chatDocBaseURL() string {
+	if c.config.ChatDocURL != "" {
+		return strings.TrimSuffix(c.config.ChatDocURL, "/")
+	}
+	return ChatDocBaseURL
+}
+
+// chatDocWebSocketURL returns the streaming chat endpoint under the base URL
+func (c *SparkClient) chatDocWebSocketURL() (string, error) {
+	u, err := url.Parse(c.chatDocBaseURL() + "/chat")
+	if err != nil {
+		return "", newConfigError("invalid document Q&A URL", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,275 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newChatDocServer serves the document Q&A API. Status requests report
+// each document as vectoring until it has been polled twice
+func newChatDocServer(t *testing.T) (*httptest.Server, *sync.Map) {
+	t.Helper()
+	uploads := &sync.Map{}
+	polls := map[string]int{}
+	var mu sync.Mutex
+
+	checkAuth := func(get func(string) string) bool {
+		ts := get("timestamp")
+		if get("appId") != "app" || ts == "" || get("signature") != chatDocSignature("app", "secret", ts) {
+			t.Errorf("bad signature: appId=%q timestamp=%q signature=%q", get("appId"), ts, get("signature"))
+			return false
+		}
+		return true
+	}
+
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openapi/v1/file/upload", func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(r.Header.Get) {
+			return
+		}
+		if r.FormValue("fileType") != "wiki" {
+			t.Errorf("fileType = %q", r.FormValue("fileType"))
+		}
+		content := r.FormValue("url")
+		if file, _, err := r.FormFile("file"); err == nil {
+			data, _ := io.ReadAll(file)
+			content = string(data)
+		}
+		uploads.Store(r.FormValue("fileName"), content)
+		fmt.Fprint(w, `{"flag":true,"code":0,"sid":"u1","data":{"fileId":"f1"}}`)
+	})
+	mux.HandleFunc("/openapi/v1/file/status", func(w http.ResponseWriter, r *http.Request) {
+		checkAuth(r.Header.Get)
+		var docs []string
+		mu.Lock()
+		for _, id := range strings.Split(r.FormValue("fileIds"), ",") {
+			if id == "unknown" {
+				continue
+			}
+			polls[id]++
+			status := DocumentVectoring
+			if polls[id] > 1 {
+				status = DocumentVectored
+				if id == "bad" {
+					status = DocumentFailed
+				}
+			}
+			docs = append(docs, fmt.Sprintf(`{"fileId":%q,"fileStatus":%q}`, id, status))
+		}
+		mu.Unlock()
+		fmt.Fprintf(w, `{"flag":true,"code":0,"data":[%s]}`, strings.Join(docs, ","))
+	})
+	mux.HandleFunc("/openapi/v1/file/list", func(w http.ResponseWriter, r *http.Request) {
+		checkAuth(r.Header.Get)
+		if r.FormValue("pageNo") != "2" || r.FormValue("pageSize") != "10" {
+			t.Errorf("unexpected page %q size %q", r.FormValue("pageNo"), r.FormValue("pageSize"))
+		}
+		fmt.Fprint(w, `{"flag":true,"code":0,"data":{"rows":[{"fileId":"f1","fileName":"a.pdf","fileStatus":"vectored"}],"total":11}}`)
+	})
+	mux.HandleFunc("/openapi/v1/file/del", func(w http.ResponseWriter, r *http.Request) {
+		checkAuth(r.Header.Get)
+		if r.FormValue("fileIds") == "missing" {
+			fmt.Fprint(w, `{"flag":false,"code":20001,"desc":"file not found","sid":"d2"}`)
+			return
+		}
+		fmt.Fprint(w, `{"flag":true,"code":0,"sid":"d1"}`)
+	})
+	mux.HandleFunc("/openapi/chat", func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(r.URL.Query().Get) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		var q DocumentQuestion
+		if err := conn.ReadJSON(&q); err != nil {
+			t.Errorf("read question: %v", err)
+			return
+		}
+		if q.FileIDs[0] == "bad" {
+			conn.WriteJSON(DocumentAnswerFrame{Code: 10001, Message: "file not ready", SID: "c2"})
+			return
+		}
+		conn.WriteJSON(DocumentAnswerFrame{SID: "c1", Content: "年假", Status: 0})
+		conn.WriteJSON(DocumentAnswerFrame{SID: "c1", Content: "五天", Status: 1})
+		conn.WriteJSON(DocumentAnswerFrame{SID: "c1", Status: 99, FileRefer: `{"f2":[3],"f1":[0,2]}`})
+		conn.WriteJSON(DocumentAnswerFrame{SID: "c1", Status: 2})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, uploads
+}
+
+func newChatDocClient(t *testing.T, server *httptest.Server) *SparkClient {
+	t.Helper()
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs("ws://unused", ""),
+		WithChatDocURL(server.URL+"/openapi/"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestSparkClient_UploadDocument(t *testing.T) {
+	server, uploads := newChatDocServer(t)
+	client := newChatDocClient(t, server)
+	ctx := context.Background()
+
+	id, err := client.UploadDocument(ctx, "handbook.txt", strings.NewReader("员工每年享有五天年假"))
+	if err != nil {
+		t.Fatalf("UploadDocument() error = %v", err)
+	}
+	if id != "f1" {
+		t.Errorf("file ID = %q, want f1", id)
+	}
+	if got, _ := uploads.Load("handbook.txt"); got != "员工每年享有五天年假" {
+		t.Errorf("uploaded content = %q", got)
+	}
+
+	if _, err := client.UploadDocumentURL(ctx, "remote.pdf", "https://example.com/remote.pdf"); err != nil {
+		t.Fatalf("UploadDocumentURL() error = %v", err)
+	}
+	if got, _ := uploads.Load("remote.pdf"); got != "https://example.com/remote.pdf" {
+		t.Errorf("uploaded URL = %q", got)
+	}
+
+	_, err = client.UploadDocument(ctx, "", strings.NewReader("x"))
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrRequest {
+		t.Errorf("empty file name error = %v, want request error", err)
+	}
+}
+
+func TestSparkClient_WaitForDocuments(t *testing.T) {
+	server, _ := newChatDocServer(t)
+	client := newChatDocClient(t, server)
+	ctx := context.Background()
+
+	docs, err := client.WaitForDocuments(ctx, time.Millisecond, "f1", "f2")
+	if err != nil {
+		t.Fatalf("WaitForDocuments() error = %v", err)
+	}
+	for _, doc := range docs {
+		if !doc.FileStatus.Ready() {
+			t.Errorf("%s status = %q, want vectored", doc.FileID, doc.FileStatus)
+		}
+	}
+
+	docs, err = client.WaitForDocuments(ctx, time.Millisecond, "bad")
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrResponse {
+		t.Fatalf("failed document error = %v, want response error", err)
+	}
+	if len(docs) != 1 || docs[0].FileStatus != DocumentFailed {
+		t.Errorf("docs = %+v", docs)
+	}
+
+	// IDs the service does not report on fail instead of being polled forever
+	_, err = client.WaitForDocuments(ctx, time.Millisecond, "f1", "unknown")
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrResponse || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("unknown document error = %v, want response error naming it", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.WaitForDocuments(cancelled, time.Hour, "f3"); err == nil {
+		t.Error("expected an error for a cancelled context")
+	}
+}
+
+func TestSparkClient_ListAndDeleteDocuments(t *testing.T) {
+	server, _ := newChatDocServer(t)
+	client := newChatDocClient(t, server)
+	ctx := context.Background()
+
+	page, err := client.ListDocuments(ctx, 2, 10)
+	if err != nil {
+		t.Fatalf("ListDocuments() error = %v", err)
+	}
+	if page.Total != 11 || len(page.Documents) != 1 || page.Documents[0].FileName != "a.pdf" {
+		t.Errorf("page = %+v", page)
+	}
+
+	if err := client.DeleteDocuments(ctx, "f1"); err != nil {
+		t.Errorf("DeleteDocuments() error = %v", err)
+	}
+	err = client.DeleteDocuments(ctx, "missing")
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Code != 20001 {
+		t.Errorf("delete error = %v, want code 20001", err)
+	}
+}
+
+func TestSparkClient_AskDocuments(t *testing.T) {
+	server, _ := newChatDocServer(t)
+	client := newChatDocClient(t, server)
+	ctx := context.Background()
+
+	var frames int
+	answer, err := client.AskDocuments(ctx, &DocumentQuestion{
+		FileIDs:  []string{"f1", "f2"},
+		Messages: []SparkMessage{{Role: "user", Content: "年假有几天?"}},
+	}, func(*DocumentAnswerFrame) { frames++ })
+	if err != nil {
+		t.Fatalf("AskDocuments() error = %v", err)
+	}
+	if answer.Content != "年假五天" || answer.SID != "c1" {
+		t.Errorf("answer = %+v", answer)
+	}
+	want := []DocumentReference{{FileID: "f1", Paragraphs: []int{0, 2}}, {FileID: "f2", Paragraphs: []int{3}}}
+	if !reflect.DeepEqual(answer.References, want) {
+		t.Errorf("references = %+v, want %+v", answer.References, want)
+	}
+	if frames != 4 {
+		t.Errorf("frames = %d, want 4", frames)
+	}
+
+	_, err = client.AskDocuments(ctx, &DocumentQuestion{
+		FileIDs:  []string{"bad"},
+		Messages: []SparkMessage{{Role: "user", Content: "?"}},
+	}, nil)
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Code != 10001 {
+		t.Errorf("error = %v, want code 10001", err)
+	}
+
+	if _, err := client.AskDocuments(ctx, &DocumentQuestion{FileIDs: []string{"f1"}}, nil); err == nil {
+		t.Error("expected an error for a question without messages")
+	}
+}
+
+func TestSparkClient_ChatDocRequiresSecret(t *testing.T) {
+	client, err := NewSparkClient(
+		WithCredentialsProvider(StaticCredentials{AppID: "app", APIKey: "key"}),
+		WithURLs("ws://unused", ""),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.DeleteDocuments(context.Background(), "f1")
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrAuthentication {
+		t.Errorf("error = %v, want authentication error", err)
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/gorilla/websocket"
+	"log/slog"
 	"net/http"
+	"time"
 )
 
 type SparkClient struct {
 	config    *Config
 	transport *http.Transport
+	logger    *slog.Logger
 }
 
 func NewSparkClient(opts ...ConfigOption) (*SparkClient, error) {
@@ -17,89 +20,179 @@ func NewSparkClient(opts ...ConfigOption) (*SparkClient, error) {
 	for _, opt := range opts {
 		opt(config)
 	}
+	return newClient(config)
+}
 
+// newClient validates config, fills in its defaults and creates a client for it
+func newClient(config *Config) (*SparkClient, error) {
 	if err := validateConfig(config); err != nil {
 		return nil, newConfigError("invalid configuration", err)
 	}
+	// Calibration follows this client's traffic only, unless an estimator is shared explicitly
+	if config.Estimator == nil {
+		config.Estimator = NewTokenEstimator()
+	}
 
 	return &SparkClient{
 		config:    config,
 		transport: defaultTransport(config.Timeout),
+		logger:    newLogger(config),
 	}, nil
 }
 
 // ChatWithCallback initiates a chat session and calls the callback function for each response
-func (c *SparkClient) ChatWithCallback(ctx context.Context, req *SparkChatRequest, callback ChatCallback) error {
-	dialer := websocket.Dialer{
-		HandshakeTimeout: c.config.Timeout,
-		NetDialContext:   c.transport.DialContext,
-		Proxy:            c.transport.Proxy,
+func (c *SparkClient) ChatWithCallback(ctx context.Context, req *SparkChatRequest, callback ChatCallback, opts ...RequestOption) error {
+	c, err := c.withRequestOptions(opts)
+	if err != nil {
+		return err
+	}
+	if err := c.validateParams(req, c.config.Domain); err != nil {
+		return err
 	}
+	call := c.newCall(CallChatStream)
+	call.Request, call.Model, call.OnFrame = req, c.primaryModel(), callback
+	_, err = c.do(ctx, call)
+	return err
+}
 
-	authURL := c.assembleAuthURL("GET", c.config.HostURL)
-	conn, _, err := dialer.DialContext(ctx, authURL, nil)
+func (c *SparkClient) Chat(ctx context.Context, req *SparkChatRequest, opts ...RequestOption) (*SparkAPIResponse, error) {
+	c, err := c.withRequestOptions(opts)
 	if err != nil {
-		return newConnectionError("failed to establish WebSocket connection", err)
+		return nil, err
 	}
-	defer conn.Close()
-
-	if err := conn.WriteJSON(c.genReqJson(req)); err != nil {
-		return newRequestError("failed to send message", err)
+	if err := c.validateParams(req, c.config.Domain); err != nil {
+		return nil, err
 	}
+	call := c.newCall(CallChat)
+	call.Request, call.Model = req, c.primaryModel()
+	result, err := c.do(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+	return result.Response, nil
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return newRequestError("request cancelled", ctx.Err())
-		default:
-			var response SparkAPIResponse
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				return newWebSocketError("failed to read message", err)
-			}
-
-			if err := json.Unmarshal(msg, &response); err != nil {
-				return newResponseError("failed to parse response", err)
-			}
-
-			if response.Header.Code != 0 {
-				return newResponseError(response.Header.Message, nil)
-			}
+// newCall describes a call made under the client's current configuration
+func (c *SparkClient) newCall(kind CallKind) *Call {
+	return &Call{Kind: kind, UID: c.config.UID, Auditing: c.config.Auditing, PatchIDs: c.config.PatchIDs}
+}
 
-			// Call the callback function with the response
-			if callback != nil {
-				callback(&response)
-			}
+// send is the innermost Handler and performs the call against the Spark API
+func (c *SparkClient) send(ctx context.Context, call *Call) (*Result, error) {
+	if call.Kind == CallEmbedding {
+		response, err := c.embed(ctx, call)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Embedding: response}, nil
+	}
 
-			if response.Payload.Choices.Status == 2 {
-				return nil
-			}
+	var answer string
+	var plugins pluginAggregator
+	var citations []Citation
+	trace := ContextClientTrace(ctx)
+	finalResponse, err := c.streamWithFallback(ctx, call, func(response *SparkAPIResponse) {
+		if len(response.Payload.Choices.Text) > 0 {
+			answer += response.Payload.Choices.Text[0].Content
+		}
+		// Search results may be spread over several frames of the same invocation
+		if found, err := ParseCitations(response.Payload.Plugins); err != nil {
+			c.logger.WarnContext(ctx, "spark citations ignored", "error", err)
+		} else {
+			citations = mergeCitations(citations, found)
+		}
+		for _, result := range plugins.add(response.Payload.Plugins) {
+			trace.pluginStatus(result)
 		}
+		if call.OnFrame != nil {
+			call.OnFrame(response)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the final frame so callers holding on to it do not see the joined content
+	joined := *finalResponse
+	if len(joined.Payload.Choices.Text) > 0 {
+		joined.Payload.Choices.Text = append([]SparkChoice(nil), joined.Payload.Choices.Text...)
+		joined.Payload.Choices.Text[0].Content = answer
 	}
+	joined.Payload.Plugins = plugins.plugins()
+	joined.Citations = citations
+	return &Result{Response: &joined}, nil
 }
 
-func (c *SparkClient) Chat(ctx context.Context, req *SparkChatRequest) (*SparkAPIResponse, error) {
-	dialer := websocket.Dialer{
-		HandshakeTimeout: c.config.Timeout,
-		NetDialContext:   c.transport.DialContext,
-		Proxy:            c.transport.Proxy,
+// stream sends the call's request to target and calls onFrame for every response frame, returning the final frame
+func (c *SparkClient) stream(ctx context.Context, target ModelTarget, call *Call, onFrame ChatCallback) (finalResponse *SparkAPIResponse, err error) {
+	start := time.Now()
+	var sid string
+	ctx, meter := c.newMeter(ctx, call.Kind, target.Domain)
+	defer func() {
+		var usage *SparkUsage
+		if finalResponse != nil {
+			usage = &finalResponse.Payload.Usage.Text
+		}
+		c.logOutcome(ctx, call.Kind, target.Domain, sid, start, usage, err)
+		meter.finish(usage, err)
+	}()
+
+	req := call.Request
+	// Parameters were validated against the primary model; fallbacks drop
+	// the ones they do not accept instead of failing the chain
+	if target != call.Model {
+		req = c.withoutUnsupportedParams(req, target.Domain)
+	}
+	req, err = c.preflight(req, target.Domain)
+	if err != nil {
+		return nil, err
 	}
 
-	authURL := c.assembleAuthURL("GET", c.config.HostURL)
-	conn, _, err := dialer.DialContext(ctx, authURL, nil)
+	conn, creds, err := c.dial(ctx, target.HostURL)
 	if err != nil {
-		return nil, newConnectionError("failed to establish WebSocket connection", err)
+		return nil, err
 	}
 	defer conn.Close()
+	defer func() {
+		var usage *SparkUsage
+		if finalResponse != nil {
+			usage = &finalResponse.Payload.Usage.Text
+		}
+		c.reportCredentials(creds, usage, err)
+	}()
 
-	if err := conn.WriteJSON(c.genReqJson(req)); err != nil {
+	// Unblock a pending read as soon as the context is cancelled
+	stopClose := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopClose()
+
+	apiReq := c.genReqJson(req, target.Domain, creds.AppID)
+	if call.UID != "" {
+		apiReq.Header.UID = call.UID
+	}
+	if call.Auditing != "" {
+		apiReq.Parameter.Chat.Auditing = call.Auditing
+	}
+	// Patches belong to the primary model and are not sent to fallbacks
+	if target == call.Model {
+		apiReq.Header.PatchID = call.PatchIDs
+	}
+	if call.OnRequest != nil {
+		call.OnRequest(apiReq)
+	}
+	if err := conn.WriteJSON(apiReq); err != nil {
 		return nil, newRequestError("failed to send message", err)
 	}
-
-	var finalResponse *SparkAPIResponse
-	var answer string
-
-	for {
+	trace := ContextClientTrace(ctx)
+	trace.requestSent()
+	c.logger.DebugContext(ctx, "spark request sent",
+		"kind", call.Kind,
+		"domain", target.Domain,
+		"uid", apiReq.Header.UID,
+		"messages", len(apiReq.Payload.Message.Text),
+		"content", c.logContent(lastMessageContent(apiReq.Payload.Message.Text)),
+	)
+
+	for first := true; ; first = false {
 		select {
 		case <-ctx.Done():
 			return nil, newRequestError("request cancelled", ctx.Err())
@@ -107,38 +200,52 @@ func (c *SparkClient) Chat(ctx context.Context, req *SparkChatRequest) (*SparkAP
 			var response SparkAPIResponse
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
+				if ctx.Err() != nil {
+					return nil, newRequestError("request cancelled", ctx.Err())
+				}
 				return nil, newWebSocketError("failed to read message", err)
 			}
+			if first {
+				trace.firstFrame()
+			}
+			meter.frame()
 
 			if err := json.Unmarshal(msg, &response); err != nil {
 				return nil, newResponseError("failed to parse response", err)
 			}
 
+			if response.Header.SID != "" {
+				sid = response.Header.SID
+			}
 			if response.Header.Code != 0 {
-				return nil, newResponseError(response.Header.Message, nil)
+				return nil, newHeaderError(response.Header)
 			}
 
+			var content string
 			if len(response.Payload.Choices.Text) > 0 {
-				answer += response.Payload.Choices.Text[0].Content
+				content = response.Payload.Choices.Text[0].Content
+			}
+			c.logger.DebugContext(ctx, "spark frame received",
+				"sid", sid,
+				"seq", response.Payload.Choices.Seq,
+				"status", response.Payload.Choices.Status,
+				"content", c.logContent(content),
+			)
+
+			response.Model = target
+			if onFrame != nil {
+				onFrame(&response)
 			}
 
 			if response.Payload.Choices.Status == 2 {
-				if len(response.Payload.Choices.Text) > 0 {
-					response.Payload.Choices.Text[0].Content = answer
-				}
-				finalResponse = &response
-				break
+				c.estimator().Calibrate(req, response.Payload.Usage.Text)
+				return &response, nil
 			}
 		}
-		if finalResponse != nil {
-			break
-		}
 	}
-
-	return finalResponse, nil
 }
 
-func (c *SparkClient) ChatSimple(ctx context.Context, prompt string) (*SparkAPIResponse, error) {
+func (c *SparkClient) ChatSimple(ctx context.Context, prompt string, opts ...RequestOption) (*SparkAPIResponse, error) {
 	req := &SparkChatRequest{
 		Messages: []SparkMessage{
 			{
@@ -147,40 +254,74 @@ func (c *SparkClient) ChatSimple(ctx context.Context, prompt string) (*SparkAPIR
 			},
 		},
 	}
-	return c.Chat(ctx, req)
+	return c.Chat(ctx, req, opts...)
 }
 
-func (c *SparkClient) Embedding(ctx context.Context, query, domain string) (*SparkAPIEmbResponse, error) {
-	dialer := websocket.Dialer{
-		HandshakeTimeout: c.config.Timeout,
-		NetDialContext:   c.transport.DialContext,
-		Proxy:            c.transport.Proxy,
+func (c *SparkClient) Embedding(ctx context.Context, query, domain string, opts ...RequestOption) (*SparkAPIEmbResponse, error) {
+	c, err := c.withRequestOptions(opts)
+	if err != nil {
+		return nil, err
 	}
+	call := c.newCall(CallEmbedding)
+	call.Query, call.EmbeddingDomain = query, domain
+	result, err := c.do(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+	return result.Embedding, nil
+}
 
-	authURL := c.assembleAuthURL("GET", c.config.EMBURL)
-	conn, _, err := dialer.DialContext(ctx, authURL, nil)
+// embed sends the call's embedding request and reads the single response frame
+func (c *SparkClient) embed(ctx context.Context, call *Call) (_ *SparkAPIEmbResponse, err error) {
+	start := time.Now()
+	var sid string
+	ctx, meter := c.newMeter(ctx, call.Kind, call.EmbeddingDomain)
+	defer func() {
+		c.logOutcome(ctx, call.Kind, call.EmbeddingDomain, sid, start, nil, err)
+		meter.finish(nil, err)
+	}()
+
+	conn, creds, err := c.dial(ctx, c.config.EMBURL)
 	if err != nil {
-		return nil, newConnectionError("failed to establish WebSocket connection", err)
+		return nil, err
 	}
 	defer conn.Close()
+	defer func() { c.reportCredentials(creds, nil, err) }()
 
-	req := c.getEmbeddingRequest(query, domain)
+	req := c.getEmbeddingRequest(call.Query, call.EmbeddingDomain, creds.AppID)
+	if call.UID != "" {
+		req.Header.UID = call.UID
+	}
+	if call.OnEmbeddingRequest != nil {
+		call.OnEmbeddingRequest(req)
+	}
 	if err := conn.WriteJSON(req); err != nil {
 		return nil, newRequestError("failed to send embedding request", err)
 	}
+	trace := ContextClientTrace(ctx)
+	trace.requestSent()
+	c.logger.DebugContext(ctx, "spark request sent",
+		"kind", call.Kind,
+		"domain", call.EmbeddingDomain,
+		"uid", req.Header.UID,
+		"content", c.logContent(call.Query),
+	)
 
 	_, message, err := conn.ReadMessage()
 	if err != nil {
 		return nil, newWebSocketError("failed to read message", err)
 	}
+	trace.firstFrame()
+	meter.frame()
 
 	var response SparkAPIEmbResponse
 	if err := json.Unmarshal(message, &response); err != nil {
 		return nil, newResponseError("failed to parse response", err)
 	}
+	sid = response.Header.SID
 
 	if response.Header.Code != 0 {
-		return nil, newResponseError(response.Header.Message, nil)
+		return nil, newHeaderError(response.Header)
 	}
 
 	return &response, nil
@@ -191,27 +332,74 @@ func (c *SparkClient) WithNewConfig(opts ...ConfigOption) (*SparkClient, error)
 	for _, opt := range opts {
 		opt(&newConfig)
 	}
+	return newClient(&newConfig)
+}
 
-	if err := validateConfig(&newConfig); err != nil {
-		return nil, newConfigError("invalid configuration", err)
+// dial signs hostURL with the client's credentials and opens a WebSocket connection to it
+func (c *SparkClient) dial(ctx context.Context, hostURL string) (*websocket.Conn, Credentials, error) {
+	authURL, creds, err := c.assembleAuthURL(ctx, "GET", hostURL)
+	if err != nil {
+		return nil, Credentials{}, err
 	}
 
-	return &SparkClient{
-		config:    &newConfig,
-		transport: defaultTransport(newConfig.Timeout),
-	}, nil
+	dialer := websocket.Dialer{
+		HandshakeTimeout: c.config.Timeout,
+		NetDialContext:   c.transport.DialContext,
+		Proxy:            c.transport.Proxy,
+	}
+
+	trace := ContextClientTrace(ctx)
+	c.logger.DebugContext(ctx, "spark dial", "url", hostURL, "app_id", creds.AppID)
+	trace.dialStart(hostURL)
+	start := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, authURL, nil)
+	if err != nil {
+		var sparkErr *SparkError
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			sparkErr = newAuthError("handshake rejected: "+readBody(resp), err)
+		} else {
+			sparkErr = newConnectionError("failed to establish WebSocket connection", err)
+		}
+		trace.dialDone(hostURL, sparkErr)
+		c.reportCredentials(creds, nil, sparkErr)
+		return nil, Credentials{}, sparkErr
+	}
+	trace.dialDone(hostURL, nil)
+	c.logger.DebugContext(ctx, "spark handshake",
+		"url", hostURL,
+		"status", resp.StatusCode,
+		"latency", time.Since(start),
+	)
+	return conn, creds, nil
 }
 
-func (c *SparkClient) genReqJson(req *SparkChatRequest) *SparkAPIRequest {
+// reportCredentials tells the credentials provider how a request signed with creds ended
+func (c *SparkClient) reportCredentials(creds Credentials, usage *SparkUsage, err error) {
+	if reporter, ok := c.config.Credentials.(CredentialsReporter); ok {
+		reporter.ReportResult(creds, usage, err)
+	}
+}
+
+func (c *SparkClient) genReqJson(req *SparkChatRequest, domain, appID string) *SparkAPIRequest {
 	apiReq := &SparkAPIRequest{}
-	apiReq.Header.AppID = c.config.AppID
+	apiReq.Header.AppID = appID
 	apiReq.Header.UID = c.config.UID
-	apiReq.Parameter.Chat.Domain = c.config.Domain
+	apiReq.Parameter.Chat.Domain = domain
 	apiReq.Parameter.Chat.Temperature = req.Temperature
 	apiReq.Parameter.Chat.TopK = req.TopK
 	apiReq.Parameter.Chat.MaxTokens = req.MaxTokens
 	apiReq.Parameter.Chat.Auditing = c.config.Auditing
 	apiReq.Parameter.Chat.QuestionType = req.QuestionType
+	apiReq.Parameter.Chat.TopP = req.TopP
+	apiReq.Parameter.Chat.PresencePenalty = req.PresencePenalty
+	apiReq.Parameter.Chat.FrequencyPenalty = req.FrequencyPenalty
+	apiReq.Parameter.Chat.Stop = req.Stop
+	apiReq.Parameter.Chat.ResponseFormat = req.ResponseFormat
+	apiReq.Parameter.Chat.ChatID = req.ChatID
+	apiReq.Parameter.Chat.Extra = req.Extra
+	if req.WebSearch != nil {
+		apiReq.Parameter.Chat.Tools = []SparkTool{{Type: "web_search", WebSearch: req.WebSearch}}
+	}
 
 	if req.System != "" {
 		apiReq.Payload.Message.Text = append(apiReq.Payload.Message.Text, SparkMessage{
@@ -233,14 +421,14 @@ func (c *SparkClient) genReqJson(req *SparkChatRequest) *SparkAPIRequest {
 	return apiReq
 }
 
-func (c *SparkClient) getEmbeddingRequest(query, domain string) *SparkAPIEmbRequest {
+func (c *SparkClient) getEmbeddingRequest(query, domain, appID string) *SparkAPIEmbRequest {
 	return &SparkAPIEmbRequest{
 		Header: struct {
 			AppID  string `json:"app_id"`
 			UID    string `json:"uid"`
 			Status int    `json:"status"`
 		}{
-			AppID:  c.config.AppID,
+			AppID:  appID,
 			UID:    c.config.UID,
 			Status: 3,
 		},
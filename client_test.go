@@ -7,8 +7,45 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+// newMockServer starts a WebSocket server that reads one request and replies with frames
+func newMockServer(t *testing.T, frames ...string) *httptest.Server {
+	t.Helper()
+	return newMockServerFunc(t, func(conn *websocket.Conn) {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		for _, frame := range frames {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// newMockServerFunc starts a WebSocket server that hands each connection to handler
+func newMockServerFunc(t *testing.T, handler func(conn *websocket.Conn)) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		handler(conn)
+	}))
+}
+
+// wsURL converts an httptest server URL into a WebSocket URL
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
 func TestNewSparkClient(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -58,8 +95,7 @@ func TestNewSparkClient(t *testing.T) {
 
 func TestSparkClient_ChatSimple(t *testing.T) {
 	// Create a mock server
-	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`{
+	mockServer := newMockServer(t, `{
             "header": {
                 "code": 0,
                 "message": "success",
@@ -87,13 +123,12 @@ func TestSparkClient_ChatSimple(t *testing.T) {
                     }
                 }
             }
-        }`))
-	}))
+        }`)
 	defer mockServer.Close()
 
 	client, err := NewSparkClient(
 		WithCredentials("test-app-id", "test-api-key", "test-secret"),
-		WithURLs(mockServer.URL, mockServer.URL),
+		WithURLs(wsURL(mockServer), wsURL(mockServer)),
 		WithTimeout(time.Second),
 	)
 	if err != nil {
@@ -144,3 +179,30 @@ func TestSparkClient_WithNewConfig(t *testing.T) {
 		t.Error("original client UID should not have changed")
 	}
 }
+
+func TestSparkClient_WithNewConfigReplacingConfig(t *testing.T) {
+	server := newMockServer(t, `{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]},"usage":{"text":{"prompt_tokens":5,"total_tokens":6}}}}`)
+	defer server.Close()
+
+	client, err := NewSparkClient(WithCredentials("app", "key", "secret"), WithURLs("ws://unused", ""))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// A config built by hand has no estimator
+	config := DefaultConfig()
+	config.Credentials = StaticCredentials{AppID: "app", APIKey: "key", APISecret: "secret"}
+	config.HostURL = wsURL(server)
+	config.Domain = "lite"
+	config.Preflight = PreflightTruncate
+	replaced, err := client.WithNewConfig(WithConfig(config))
+	if err != nil {
+		t.Fatalf("WithNewConfig failed: %v", err)
+	}
+	if replaced.estimator() == nil {
+		t.Fatal("expected a default estimator")
+	}
+	if _, err := replaced.ChatSimple(context.Background(), "hi"); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package gosparkclient
+
+import "context"
+
+// ClientTrace is a set of hooks run at the stages of a request that middleware
+// cannot observe, in the spirit of net/http/httptrace. With a fallback policy
+// the hooks run once per attempted model. Any hook may be nil
+type ClientTrace struct {
+	// DialStart is called before the WebSocket connection to hostURL is opened
+	DialStart func(hostURL string)
+
+	// DialDone is called when the WebSocket handshake completes or fails
+	DialDone func(hostURL string, err error)
+
+	// RequestSent is called once the request has been written to the connection
+	RequestSent func()
+
+	// FirstFrame is called when the first response frame arrives
+	FirstFrame func()
+
+	// PluginStatus is called when a plugin invoked by the model first reports
+	// a result and whenever its status changes
+	PluginStatus func(result PluginResult)
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace returns a context carrying trace. Hooks already present in
+// ctx keep running, after those of trace
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	if old := ContextClientTrace(ctx); old != nil {
+		trace = composeClientTrace(trace, old)
+	}
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace carried by ctx, or nil
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// composeClientTrace returns a trace that runs the hooks of first, then second
+func composeClientTrace(first, second *ClientTrace) *ClientTrace {
+	return &ClientTrace{
+		DialStart: func(hostURL string) {
+			first.dialStart(hostURL)
+			second.dialStart(hostURL)
+		},
+		DialDone: func(hostURL string, err error) {
+			first.dialDone(hostURL, err)
+			second.dialDone(hostURL, err)
+		},
+		RequestSent: func() {
+			first.requestSent()
+			second.requestSent()
+		},
+		FirstFrame: func() {
+			first.firstFrame()
+			second.firstFrame()
+		},
+		PluginStatus: func(result PluginResult) {
+			first.pluginStatus(result)
+			second.pluginStatus(result)
+		},
+	}
+}
+
+func (t *ClientTrace) dialStart(hostURL string) {
+	if t != nil && t.DialStart != nil {
+		t.DialStart(hostURL)
+	}
+}
+
+func (t *ClientTrace) dialDone(hostURL string, err error) {
+	if t != nil && t.DialDone != nil {
+		t.DialDone(hostURL, err)
+	}
+}
+
+func (t *ClientTrace) requestSent() {
+	if t != nil && t.RequestSent != nil {
+		t.RequestSent()
+	}
+}
+
+func (t *ClientTrace) firstFrame() {
+	if t != nil && t.FirstFrame != nil {
+		t.FirstFrame()
+	}
+}
+
+func (t *ClientTrace) pluginStatus(result PluginResult) {
+	if t != nil && t.PluginStatus != nil {
+		t.PluginStatus(result)
+	}
+}
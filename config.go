@@ -2,6 +2,7 @@ package gosparkclient
 
 import (
 	"errors"
+	"log/slog"
 	"time"
 )
 
@@ -11,17 +12,37 @@ const (
 	defaultAuditing = "default"
 )
 
-// Config holds all configuration options for SparkClient
+// Config holds all configuration options for SparkClient.
+// Secret material is never stored here; it is obtained from Credentials per request
 type Config struct {
-	AppID     string
-	ApiSecret string
-	ApiKey    string
-	HostURL   string
-	EMBURL    string
-	Domain    string
-	Timeout   time.Duration
-	UID       string
-	Auditing  string
+	AppID       string
+	HostURL     string
+	EMBURL      string
+	ImageURL    string
+	ChatDocURL  string
+	Domain      string
+	Timeout     time.Duration
+	UID         string
+	Auditing    string
+	Credentials CredentialsProvider
+	Signer      Signer
+	Fallback    *FallbackPolicy
+	Middlewares []Middleware
+	Logger      *slog.Logger
+	LogContent  bool
+	Metrics     MetricsHook
+
+	Preflight      PreflightMode
+	Estimator      *TokenEstimator
+	ContextWindows map[string]int
+
+	ModelParameters map[string][]string
+
+	// PatchIDs are the resource IDs of a fine-tuned model, sent with requests to the primary model
+	PatchIDs []string
+
+	// AssistantBaseURL is the endpoint prefix of assistants, AssistantBaseURL by default
+	AssistantBaseURL string
 }
 
 // ConfigOption defines a function type for setting config options
@@ -33,23 +54,30 @@ func DefaultConfig() *Config {
 		Timeout:  defaultTimeout,
 		UID:      defaultUID,
 		Auditing: defaultAuditing,
+		Signer:   &HMACSigner{},
 	}
 }
 
 // validateConfig checks if the configuration is valid
 func validateConfig(c *Config) error {
-	if c.AppID == "" {
+	// AppID may come from the credentials provider instead of the config
+	if c.AppID == "" && c.Credentials == nil {
 		return errors.New("AppID is required")
 	}
-	if c.ApiSecret == "" {
-		return errors.New("ApiSecret is required")
-	}
-	if c.ApiKey == "" {
-		return errors.New("ApiKey is required")
+	if c.Credentials == nil {
+		return errors.New("credentials provider is required")
 	}
 	if c.HostURL == "" {
 		return errors.New("HostURL is required")
 	}
+	if err := validateFineTune(c.Domain, c.PatchIDs); err != nil {
+		return err
+	}
+	if c.Fallback != nil {
+		if err := c.Fallback.validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -74,12 +102,25 @@ func WithAuditing(auditing string) ConfigOption {
 	}
 }
 
-// WithCredentials sets the credentials for the client
+// WithCredentials sets static credentials for the client
 func WithCredentials(appID, apiKey, apiSecret string) ConfigOption {
 	return func(c *Config) {
 		c.AppID = appID
-		c.ApiKey = apiKey
-		c.ApiSecret = apiSecret
+		c.Credentials = StaticCredentials{AppID: appID, APIKey: apiKey, APISecret: apiSecret}
+	}
+}
+
+// WithCredentialsProvider sets the provider the client retrieves credentials from
+func WithCredentialsProvider(provider CredentialsProvider) ConfigOption {
+	return func(c *Config) {
+		c.Credentials = provider
+	}
+}
+
+// WithSigner sets the signer used to authenticate request URLs
+func WithSigner(signer Signer) ConfigOption {
+	return func(c *Config) {
+		c.Signer = signer
 	}
 }
 
@@ -91,6 +132,20 @@ func WithURLs(hostURL, embURL string) ConfigOption {
 	}
 }
 
+// WithImageGenerationURL sets the text-to-image endpoint, ImageGenerationURL by default
+func WithImageGenerationURL(imageURL string) ConfigOption {
+	return func(c *Config) {
+		c.ImageURL = imageURL
+	}
+}
+
+// WithChatDocURL sets the base URL of the document Q&A API, ChatDocBaseURL by default
+func WithChatDocURL(baseURL string) ConfigOption {
+	return func(c *Config) {
+		c.ChatDocURL = baseURL
+	}
+}
+
 // WithDomain sets the domain for the client
 func WithDomain(domain string) ConfigOption {
 	return func(c *Config) {
@@ -98,6 +153,118 @@ func WithDomain(domain string) ConfigOption {
 	}
 }
 
+// WithImageUnderstanding points the client at the image understanding endpoint
+func WithImageUnderstanding() ConfigOption {
+	return func(c *Config) {
+		c.HostURL = ImageUnderstandingURL
+		c.Domain = ImageUnderstandingDomain
+	}
+}
+
+// WithFallback sets the models tried when the primary model fails
+func WithFallback(policy FallbackPolicy) ConfigOption {
+	return func(c *Config) {
+		c.Fallback = &policy
+	}
+}
+
+// WithMiddleware appends middleware to the client's call chain.
+// The first middleware registered is the outermost
+func WithMiddleware(mw ...Middleware) ConfigOption {
+	return func(c *Config) {
+		c.Middlewares = append(c.Middlewares, mw...)
+	}
+}
+
+// WithLogger sets the structured logger the client reports its activity to.
+// Secrets and signed URLs are always redacted from the output
+func WithLogger(logger *slog.Logger) ConfigOption {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
+
+// WithContentLogging controls whether message content is included in log
+// records. It is redacted by default
+func WithContentLogging(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.LogContent = enabled
+	}
+}
+
+// WithMetrics sets the hook that receives per-request measurements
+func WithMetrics(hook MetricsHook) ConfigOption {
+	return func(c *Config) {
+		c.Metrics = hook
+	}
+}
+
+// WithUsageLedger records the token usage of every chat call in ledger
+func WithUsageLedger(ledger *UsageLedger) ConfigOption {
+	return WithMiddleware(ledger.Middleware())
+}
+
+// WithBudget enforces the enforcer's budget policies on every chat call. Usage
+// is recorded in the enforcer's ledger, so WithUsageLedger is not needed as well
+func WithBudget(enforcer *BudgetEnforcer) ConfigOption {
+	return WithMiddleware(enforcer.Middleware())
+}
+
+// WithCache serves repeated chat and embedding calls from cache
+func WithCache(cache Cache, opts ...CacheOption) ConfigOption {
+	return WithMiddleware(CacheMiddleware(cache, opts...))
+}
+
+// WithSemanticCache answers single-turn chat calls from cache when a similar question was asked before
+func WithSemanticCache(cache *SemanticCache) ConfigOption {
+	return WithMiddleware(cache.Middleware())
+}
+
+// WithSingleflight collapses identical concurrent chat and embedding calls into one upstream call
+func WithSingleflight() ConfigOption {
+	return WithMiddleware(SingleflightMiddleware())
+}
+
+// WithPreflight sets how requests that exceed the model's context window are handled
+func WithPreflight(mode PreflightMode) ConfigOption {
+	return func(c *Config) {
+		c.Preflight = mode
+	}
+}
+
+// WithTokenEstimator sets the estimator used for preflight checks and budgets.
+// It is calibrated with the usage of every response. By default each client
+// has its own estimator
+func WithTokenEstimator(estimator *TokenEstimator) ConfigOption {
+	return func(c *Config) {
+		c.Estimator = estimator
+	}
+}
+
+// WithContextWindow overrides the context window, in tokens, of domain
+func WithContextWindow(domain string, tokens int) ConfigOption {
+	return func(c *Config) {
+		windows := make(map[string]int, len(c.ContextWindows)+1)
+		for d, t := range c.ContextWindows {
+			windows[d] = t
+		}
+		windows[domain] = tokens
+		c.ContextWindows = windows
+	}
+}
+
+// WithModelParameters overrides which of the Param* parameters domain accepts
+func WithModelParameters(domain string, params ...string) ConfigOption {
+	return func(c *Config) {
+		supported := make(map[string][]string, len(c.ModelParameters)+1)
+		for d, p := range c.ModelParameters {
+			supported[d] = p
+		}
+		supported[domain] = append([]string{}, params...)
+		c.ModelParameters = supported
+	}
+}
+
 // WithConfig sets the entire configuration
 func WithConfig(config *Config) ConfigOption {
 	return func(c *Config) {
@@ -0,0 +1,124 @@
+package gosparkclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Credentials holds a single iFlytek credential set
+type Credentials struct {
+	AppID     string `json:"app_id"`
+	APIKey    string `json:"api_key"`
+	APISecret string `json:"api_secret"`
+}
+
+// CredentialsProvider supplies the credentials used to sign each request.
+// Retrieve is called once per request, so implementations can pick up
+// rotated secrets without rebuilding the client
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// CredentialsReporter is implemented by providers that want to learn how each
+// request signed with their credentials ended. usage is nil when the request
+// failed or returned no token usage
+type CredentialsReporter interface {
+	ReportResult(creds Credentials, usage *SparkUsage, err error)
+}
+
+// CredentialsProviderFunc adapts an ordinary function to a CredentialsProvider
+type CredentialsProviderFunc func(ctx context.Context) (Credentials, error)
+
+// Retrieve calls f(ctx)
+func (f CredentialsProviderFunc) Retrieve(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// StaticCredentials is a CredentialsProvider that always returns the same credentials
+type StaticCredentials Credentials
+
+// Retrieve returns the static credentials
+func (s StaticCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	return Credentials(s), nil
+}
+
+// EnvCredentials reads credentials from environment variables on every request.
+// Empty variable names fall back to SPARKAI_APP_ID, SPARKAI_API_KEY and SPARKAI_API_SECRET
+type EnvCredentials struct {
+	AppIDVar     string
+	APIKeyVar    string
+	APISecretVar string
+}
+
+// Retrieve reads the configured environment variables
+func (e EnvCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	creds := Credentials{
+		AppID:     os.Getenv(envOrDefault(e.AppIDVar, "SPARKAI_APP_ID")),
+		APIKey:    os.Getenv(envOrDefault(e.APIKeyVar, "SPARKAI_API_KEY")),
+		APISecret: os.Getenv(envOrDefault(e.APISecretVar, "SPARKAI_API_SECRET")),
+	}
+	if creds.APIKey == "" {
+		return Credentials{}, errors.New("ApiKey is not set in the environment")
+	}
+	return creds, nil
+}
+
+func envOrDefault(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// FileCredentials reads credentials from a JSON file with app_id, api_key and
+// api_secret keys. The file is re-read whenever its modification time changes,
+// so secrets rotated on disk are picked up by the next request
+type FileCredentials struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	creds   Credentials
+}
+
+// NewFileCredentials returns a FileCredentials reading from path
+func NewFileCredentials(path string) *FileCredentials {
+	return &FileCredentials{Path: path}
+}
+
+// Retrieve returns the credentials in the file, reloading it if it has changed
+func (f *FileCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("stat credentials file: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.modTime.IsZero() && info.ModTime().Equal(f.modTime) {
+		return f.creds, nil
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("read credentials file: %w", err)
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return Credentials{}, fmt.Errorf("parse credentials file: %w", err)
+	}
+	if creds.APIKey == "" {
+		return Credentials{}, errors.New("ApiKey is missing from credentials file")
+	}
+
+	f.creds = creds
+	f.modTime = info.ModTime()
+	return creds, nil
+}
@@ -0,0 +1,160 @@
+package gosparkclient
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFileCredentials_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "creds.json")
+	write := func(secret string, modTime time.Time) {
+		data := `{"app_id":"app","api_key":"key","api_secret":"` + secret + `"}`
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	provider := NewFileCredentials(path)
+	write("old-secret", time.Now().Add(-time.Hour))
+
+	creds, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if creds.APISecret != "old-secret" || creds.AppID != "app" {
+		t.Errorf("unexpected credentials: %+v", creds)
+	}
+
+	write("new-secret", time.Now())
+	creds, err = provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if creds.APISecret != "new-secret" {
+		t.Errorf("expected rotated secret, got %q", creds.APISecret)
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	t.Setenv("MY_APP", "env-app")
+	t.Setenv("MY_KEY", "env-key")
+	t.Setenv("MY_SECRET", "env-secret")
+
+	creds, err := EnvCredentials{AppIDVar: "MY_APP", APIKeyVar: "MY_KEY", APISecretVar: "MY_SECRET"}.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if creds != (Credentials{AppID: "env-app", APIKey: "env-key", APISecret: "env-secret"}) {
+		t.Errorf("unexpected credentials: %+v", creds)
+	}
+
+	t.Setenv("MY_KEY", "")
+	if _, err := (EnvCredentials{APIKeyVar: "MY_KEY"}).Retrieve(context.Background()); err == nil {
+		t.Error("expected error for missing ApiKey")
+	}
+}
+
+func TestHMACSigner_ExternalDigest(t *testing.T) {
+	fixed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	creds := Credentials{APIKey: "key", APISecret: "secret"}
+
+	local := &HMACSigner{Now: func() time.Time { return fixed }}
+	want, err := local.Sign(context.Background(), "GET", "wss://spark-api.xf-yun.com/v3.5/chat", creds)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	// A stand-in for an external signing service that owns the secret
+	remote := &HMACSigner{
+		Now: func() time.Time { return fixed },
+		Digest: func(ctx context.Context, data string, c Credentials) (string, error) {
+			if c.APISecret != "" {
+				return "", errors.New("secret must not be sent to the signing service")
+			}
+			return hmacSha256ToBase64(data, "secret"), nil
+		},
+	}
+	got, err := remote.Sign(context.Background(), "GET", "wss://spark-api.xf-yun.com/v3.5/chat", Credentials{APIKey: "key"})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("remote signature differs from local signature\n got: %s\nwant: %s", got, want)
+	}
+
+	u, _ := url.Parse(got)
+	auth, _ := base64.StdEncoding.DecodeString(u.Query().Get("authorization"))
+	if !strings.Contains(string(auth), `username="key"`) {
+		t.Errorf("authorization missing api key: %s", auth)
+	}
+}
+
+func TestSparkClient_CredentialsProvider(t *testing.T) {
+	var gotAppID string
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		gotAppID = req.Header.AppID
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]}}}`))
+	})
+	defer server.Close()
+
+	calls := 0
+	client, err := NewSparkClient(
+		WithCredentialsProvider(CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+			calls++
+			return Credentials{AppID: "provided-app", APIKey: "key", APISecret: "secret"}, nil
+		})),
+		WithURLs(wsURL(server), ""),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if _, err := client.ChatSimple(context.Background(), "hi"); err != nil {
+		t.Fatalf("ChatSimple failed: %v", err)
+	}
+	if gotAppID != "provided-app" {
+		t.Errorf("expected AppID from provider, got %q", gotAppID)
+	}
+	if calls != 1 {
+		t.Errorf("expected provider to be called once, got %d", calls)
+	}
+}
+
+func TestSparkClient_HandshakeRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"HMAC signature does not match"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	_, err = client.ChatSimple(context.Background(), "hi")
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrAuthentication {
+		t.Fatalf("expected authentication error, got %v", err)
+	}
+}
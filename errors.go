@@ -1,6 +1,7 @@
 package gosparkclient
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -14,21 +15,35 @@ const (
 	ErrRequest        ErrorType = "RequestError"
 	ErrResponse       ErrorType = "ResponseError"
 	ErrWebSocket      ErrorType = "WebSocketError"
+	ErrBudgetExceeded ErrorType = "BudgetExceededError"
+)
+
+// Spark service error codes returned in the response header
+const (
+	CodeAppIDUnauthorized = 11200 // AppID lacks authorization or its quota is used up
+	CodeDailyQuotaLimit   = 11201 // daily request quota exceeded
+	CodeQPSLimit          = 11202 // per-second request limit exceeded
+	CodeConcurrencyLimit  = 11203 // concurrent connection limit exceeded
 )
 
 // SparkError represents a custom error type for the Spark client
 type SparkError struct {
 	Type    ErrorType
+	Code    int
 	Message string
 	Err     error
 }
 
 // Error implements the error interface
 func (e *SparkError) Error() string {
+	msg := e.Message
+	if e.Code != 0 {
+		msg = fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+	}
 	if e.Err != nil {
-		return fmt.Sprintf("%s: %s (underlying: %v)", e.Type, e.Message, e.Err)
+		return fmt.Sprintf("%s: %s (underlying: %v)", e.Type, msg, e.Err)
 	}
-	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+	return fmt.Sprintf("%s: %s", e.Type, msg)
 }
 
 // Unwrap returns the underlying error
@@ -69,3 +84,31 @@ func newResponseError(message string, err error) *SparkError {
 func newWebSocketError(message string, err error) *SparkError {
 	return NewSparkError(ErrWebSocket, message, err)
 }
+
+func newBudgetError(message string, err error) *SparkError {
+	return NewSparkError(ErrBudgetExceeded, message, err)
+}
+
+// newHeaderError converts a non-zero response header into a SparkError
+func newHeaderError(header SparkHeader) *SparkError {
+	sparkErr := newResponseError(header.Message, nil)
+	sparkErr.Code = header.Code
+	return sparkErr
+}
+
+// isCredentialError reports whether err was caused by the credentials used
+// rather than the request itself, i.e. an authentication or quota failure
+func isCredentialError(err error) bool {
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) {
+		return false
+	}
+	if sparkErr.Type == ErrAuthentication {
+		return true
+	}
+	switch sparkErr.Code {
+	case CodeAppIDUnauthorized, CodeDailyQuotaLimit, CodeQPSLimit, CodeConcurrencyLimit:
+		return true
+	}
+	return false
+}
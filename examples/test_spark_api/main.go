@@ -6,6 +6,7 @@ import (
 	"github.com/fruitbars/gosparkclient"
 	"github.com/joho/godotenv"
 	"log"
+	"log/slog"
 	"os"
 	"time"
 )
@@ -30,6 +31,7 @@ func main() {
 		gosparkclient.WithURLs(hostURL, ""), // embedding URL 可选
 		gosparkclient.WithDomain(domain),
 		gosparkclient.WithTimeout(time.Second*60),
+		gosparkclient.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, nil))), // 结构化日志，密钥自动脱敏
 	)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
@@ -0,0 +1,130 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+)
+
+// Spark service error codes that indicate an overloaded or failing model
+const (
+	CodeEngineInternalError = 10012 // internal engine error
+	CodeServiceBusy         = 10110 // service busy, no free capacity
+	CodeNetworkError        = 10222 // upstream network error
+)
+
+// ModelTarget identifies the endpoint and domain of a Spark model
+type ModelTarget struct {
+	HostURL string
+	Domain  string
+}
+
+// FallbackPolicy describes the models tried, in order, when the client's own
+// HostURL and Domain fail before any response frame has been received
+type FallbackPolicy struct {
+	Targets []ModelTarget
+
+	// Classes lists the error types that move on to the next target.
+	// ResponseError is only considered when its code is also listed in Codes
+	Classes []ErrorType
+
+	// Codes lists the Spark error codes that move on to the next target
+	Codes []int
+}
+
+// DefaultFallbackPolicy returns a policy that falls back to targets on
+// connection failures and on overloaded or internal server errors
+func DefaultFallbackPolicy(targets ...ModelTarget) FallbackPolicy {
+	return FallbackPolicy{
+		Targets: targets,
+		Classes: []ErrorType{ErrConnection, ErrWebSocket, ErrResponse},
+		Codes: []int{
+			CodeEngineInternalError,
+			CodeServiceBusy,
+			CodeNetworkError,
+			CodeQPSLimit,
+			CodeConcurrencyLimit,
+		},
+	}
+}
+
+// shouldFallback reports whether err allows trying the next target
+func (p *FallbackPolicy) shouldFallback(err error) bool {
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) {
+		return false
+	}
+
+	classMatched := false
+	for _, class := range p.Classes {
+		if class == sparkErr.Type {
+			classMatched = true
+			break
+		}
+	}
+	if !classMatched {
+		return false
+	}
+
+	if sparkErr.Type != ErrResponse {
+		return true
+	}
+	for _, code := range p.Codes {
+		if code == sparkErr.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that every fallback target is usable
+func (p *FallbackPolicy) validate() error {
+	for _, target := range p.Targets {
+		if target.HostURL == "" || target.Domain == "" {
+			return errors.New("fallback targets require HostURL and Domain")
+		}
+	}
+	return nil
+}
+
+// primaryModel returns the model configured on the client
+func (c *SparkClient) primaryModel() ModelTarget {
+	return ModelTarget{HostURL: c.config.HostURL, Domain: c.config.Domain}
+}
+
+// targets returns the call's primary model followed by the client's fallbacks.
+// Calls to an assistant have no fallbacks
+func (c *SparkClient) targets(call *Call) []ModelTarget {
+	targets := []ModelTarget{call.Model}
+	if c.config.Fallback != nil && !c.config.isAssistant(call.Model.HostURL) {
+		targets = append(targets, c.config.Fallback.Targets...)
+	}
+	return targets
+}
+
+// streamWithFallback runs stream against each target until one succeeds.
+// Once a frame has been delivered to onFrame the error is returned as is,
+// since the caller has already seen part of an answer
+func (c *SparkClient) streamWithFallback(ctx context.Context, call *Call, onFrame ChatCallback) (*SparkAPIResponse, error) {
+	targets := c.targets(call)
+
+	var err error
+	for i, target := range targets {
+		delivered := false
+		var finalResponse *SparkAPIResponse
+		finalResponse, err = c.stream(ctx, target, call, func(response *SparkAPIResponse) {
+			delivered = true
+			if onFrame != nil {
+				onFrame(response)
+			}
+		})
+		if err == nil {
+			return finalResponse, nil
+		}
+
+		last := i == len(targets)-1
+		if last || delivered || ctx.Err() != nil || !c.config.Fallback.shouldFallback(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
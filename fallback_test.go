@@ -0,0 +1,162 @@
+package gosparkclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newDomainServer replies with the frames registered for the requested domain
+func newDomainServer(t *testing.T, frames map[string][]string) (string, func()) {
+	t.Helper()
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		for _, frame := range frames[req.Parameter.Chat.Domain] {
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+	})
+	return wsURL(server), server.Close
+}
+
+func TestSparkClient_FallbackChain(t *testing.T) {
+	url, closeServer := newDomainServer(t, map[string][]string{
+		"4.0Ultra":    {`{"header":{"code":10110,"message":"service busy"}}`},
+		"generalv3.5": {`{"header":{"code":10012,"message":"internal error"}}`},
+		"lite":        {`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"from lite"}]}}}`},
+	})
+	defer closeServer()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(url, ""),
+		WithDomain("4.0Ultra"),
+		WithFallback(DefaultFallbackPolicy(
+			ModelTarget{HostURL: url, Domain: "generalv3.5"},
+			ModelTarget{HostURL: url, Domain: "lite"},
+		)),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	resp, err := client.ChatSimple(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("ChatSimple failed: %v", err)
+	}
+	if resp.Model.Domain != "lite" {
+		t.Errorf("expected response from lite, got %q", resp.Model.Domain)
+	}
+	if resp.Payload.Choices.Text[0].Content != "from lite" {
+		t.Errorf("unexpected content %q", resp.Payload.Choices.Text[0].Content)
+	}
+}
+
+func TestSparkClient_FallbackDropsUnsupportedParams(t *testing.T) {
+	frames := map[string]string{
+		"4.0Ultra":    `{"header":{"code":10110,"message":"service busy"}}`,
+		"generalv3.5": `{"header":{"code":10012,"message":"internal error"}}`,
+		"lite":        `{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"from lite"}]}}}`,
+	}
+	sent := make(chan SparkAPIRequest, 3)
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		sent <- req
+		conn.WriteMessage(websocket.TextMessage, []byte(frames[req.Parameter.Chat.Domain]))
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+		WithDomain("4.0Ultra"),
+		WithFallback(DefaultFallbackPolicy(
+			ModelTarget{HostURL: wsURL(server), Domain: "generalv3.5"},
+			ModelTarget{HostURL: wsURL(server), Domain: "lite"},
+		)),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	req := &SparkChatRequest{
+		Messages: []SparkMessage{{Role: "user", Content: "hi"}},
+		TopP:     0.5,
+		Stop:     []string{"。"},
+	}
+	resp, err := client.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if resp.Model.Domain != "lite" {
+		t.Errorf("expected response from lite, got %q", resp.Model.Domain)
+	}
+
+	primary := <-sent
+	if primary.Parameter.Chat.TopP != 0.5 || len(primary.Parameter.Chat.Stop) != 1 {
+		t.Errorf("primary request lost its parameters: %+v", primary.Parameter.Chat)
+	}
+	for i := 0; i < 2; i++ {
+		if fallback := <-sent; fallback.Parameter.Chat.TopP != 0 || fallback.Parameter.Chat.Stop != nil {
+			t.Errorf("%s received unsupported parameters: %+v", fallback.Parameter.Chat.Domain, fallback.Parameter.Chat)
+		}
+	}
+	if req.TopP != 0.5 || len(req.Stop) != 1 {
+		t.Error("caller's request must not be modified")
+	}
+}
+
+func TestSparkClient_FallbackRules(t *testing.T) {
+	url, closeServer := newDomainServer(t, map[string][]string{
+		"4.0Ultra": {`{"header":{"code":10013,"message":"content rejected"}}`},
+		"streamed": {
+			`{"header":{"code":0},"payload":{"choices":{"status":1,"text":[{"content":"partial"}]}}}`,
+			`{"header":{"code":10110,"message":"service busy"}}`,
+		},
+		"lite": {`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"from lite"}]}}}`},
+	})
+	defer closeServer()
+
+	tests := []struct {
+		name   string
+		domain string
+	}{
+		{name: "error code not in policy", domain: "4.0Ultra"},
+		{name: "error after first frame", domain: "streamed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewSparkClient(
+				WithCredentials("app", "key", "secret"),
+				WithURLs(url, ""),
+				WithDomain(tt.domain),
+				WithFallback(DefaultFallbackPolicy(ModelTarget{HostURL: url, Domain: "lite"})),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+
+			var frames []string
+			err = client.ChatWithCallback(context.Background(), &SparkChatRequest{
+				Messages: []SparkMessage{{Role: "user", Content: "hi"}},
+			}, func(resp *SparkAPIResponse) {
+				frames = append(frames, resp.Model.Domain)
+			})
+			if err == nil {
+				t.Fatal("expected error without fallback")
+			}
+			for _, domain := range frames {
+				if domain != tt.domain {
+					t.Errorf("unexpected frame from %q", domain)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package gosparkclient
+
+import (
+	"errors"
+	"strings"
+)
+
+// FineTuneURL is the chat endpoint of models fine-tuned on the iFlytek MaaS platform
+const FineTuneURL = "wss://maas-api.cn-huabei-1.xf-yun.com/v1.1/chat"
+
+// FineTunedModel identifies a fine-tuned model. Its domain is the service ID
+// shown on the platform, and PatchIDs are the resource IDs of the fine-tuning
+// patches to apply on top of the base model
+type FineTunedModel struct {
+	HostURL   string // FineTuneURL when empty
+	ServiceID string
+	PatchIDs  []string
+}
+
+// apply configures c for the model
+func (m FineTunedModel) apply(c *Config) {
+	c.HostURL = m.HostURL
+	if c.HostURL == "" {
+		c.HostURL = FineTuneURL
+	}
+	c.Domain = m.ServiceID
+	c.PatchIDs = append([]string{}, m.PatchIDs...)
+}
+
+// WithFineTunedModel points the client at a fine-tuned model
+func WithFineTunedModel(model FineTunedModel) ConfigOption {
+	return func(c *Config) {
+		model.apply(c)
+	}
+}
+
+// WithPatchIDs sets the fine-tuning patches applied to the configured model
+func WithPatchIDs(patchIDs ...string) ConfigOption {
+	return func(c *Config) {
+		c.PatchIDs = append([]string(nil), patchIDs...)
+	}
+}
+
+// WithRequestFineTunedModel sends a single call to a fine-tuned model
+func WithRequestFineTunedModel(model FineTunedModel) RequestOption {
+	return func(c *Config) {
+		model.apply(c)
+	}
+}
+
+// WithRequestPatchIDs sets the fine-tuning patches of a single call. Without
+// arguments the call is sent without patches
+func WithRequestPatchIDs(patchIDs ...string) RequestOption {
+	return func(c *Config) {
+		c.PatchIDs = append([]string{}, patchIDs...)
+	}
+}
+
+// validateFineTune rejects blank or duplicate patch IDs and patches without a model to apply them to
+func validateFineTune(domain string, patchIDs []string) error {
+	if len(patchIDs) > 0 && domain == "" {
+		return errors.New("patch IDs require the service ID of the fine-tuned model as domain")
+	}
+	seen := make(map[string]bool, len(patchIDs))
+	for _, id := range patchIDs {
+		if strings.TrimSpace(id) == "" {
+			return errors.New("patch ID must not be empty")
+		}
+		if seen[id] {
+			return errors.New("duplicate patch ID " + id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
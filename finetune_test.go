@@ -0,0 +1,144 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWithFineTunedModel(t *testing.T) {
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithFineTunedModel(FineTunedModel{ServiceID: "xsp123", PatchIDs: []string{"res-1"}}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if client.config.HostURL != FineTuneURL || client.config.Domain != "xsp123" || !reflect.DeepEqual(client.config.PatchIDs, []string{"res-1"}) {
+		t.Errorf("unexpected config %+v", client.config)
+	}
+
+	invalid := []struct {
+		name string
+		opts []ConfigOption
+	}{
+		{"blank patch", []ConfigOption{WithDomain("lite"), WithPatchIDs(" ")}},
+		{"duplicate patch", []ConfigOption{WithDomain("lite"), WithPatchIDs("a", "a")}},
+		{"no service ID", []ConfigOption{WithFineTunedModel(FineTunedModel{PatchIDs: []string{"a"}})}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]ConfigOption{WithCredentials("app", "key", "secret"), WithURLs("ws://unused", "")}, tt.opts...)
+			if _, err := NewSparkClient(opts...); err == nil {
+				t.Error("expected configuration error")
+			}
+		})
+	}
+}
+
+func TestSparkClient_PatchIDs(t *testing.T) {
+	requests := make(chan SparkAPIRequest, 4)
+	failing := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		requests <- req
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":10012,"message":"internal error"}}`))
+	})
+	defer failing.Close()
+	healthy := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		requests <- req
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]}}}`))
+	})
+	defer healthy.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithFineTunedModel(FineTunedModel{HostURL: wsURL(failing), ServiceID: "xsp123", PatchIDs: []string{"res-1"}}),
+		WithFallback(DefaultFallbackPolicy(ModelTarget{HostURL: wsURL(healthy), Domain: "lite"})),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if _, err := client.ChatSimple(context.Background(), "hi"); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	primary, fallback := <-requests, <-requests
+	if primary.Parameter.Chat.Domain != "xsp123" || !reflect.DeepEqual(primary.Header.PatchID, []string{"res-1"}) {
+		t.Errorf("unexpected primary request header %+v", primary.Header)
+	}
+	if fallback.Parameter.Chat.Domain != "lite" || fallback.Header.PatchID != nil {
+		t.Errorf("patch IDs must not be sent to the fallback, got %+v", fallback.Header)
+	}
+
+	// Per-request patches replace the configured ones
+	_, err = client.ChatSimple(context.Background(), "hi",
+		WithRequestFineTunedModel(FineTunedModel{HostURL: wsURL(healthy), ServiceID: "xsp456", PatchIDs: []string{"res-2", "res-3"}}))
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if req := <-requests; req.Parameter.Chat.Domain != "xsp456" || !reflect.DeepEqual(req.Header.PatchID, []string{"res-2", "res-3"}) {
+		t.Errorf("unexpected request header %+v", req.Header)
+	}
+
+	_, err = client.ChatSimple(context.Background(), "hi", WithRequestPatchIDs(""))
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrRequest {
+		t.Errorf("expected request error for blank patch ID, got %v", err)
+	}
+	if len(requests) != 0 {
+		t.Error("invalid patches must be rejected before any model is called")
+	}
+}
+
+func TestSparkClient_PatchIDsFollowTheModel(t *testing.T) {
+	requests := make(chan SparkAPIRequest, 1)
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		requests <- req
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]}}}`))
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithFineTunedModel(FineTunedModel{HostURL: wsURL(server), ServiceID: "xsp123", PatchIDs: []string{"res-1"}}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts []RequestOption
+		want []string
+	}{
+		{"same model", []RequestOption{WithRequestUID("user-1")}, []string{"res-1"}},
+		{"other domain", []RequestOption{WithRequestDomain("4.0Ultra")}, nil},
+		{"other model", []RequestOption{WithRequestModel(ModelTarget{HostURL: wsURL(server), Domain: "lite"})}, nil},
+		{"other model with patches", []RequestOption{WithRequestPatchIDs("res-9"), WithRequestDomain("xsp789")}, []string{"res-9"}},
+		{"patches removed", []RequestOption{WithRequestPatchIDs()}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.ChatSimple(context.Background(), "hi", tt.opts...); err != nil {
+				t.Fatalf("Chat failed: %v", err)
+			}
+			if got := (<-requests).Header.PatchID; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("patch_id = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package gosparkclient
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Message content types
+const (
+	ContentTypeText  = "text"
+	ContentTypeImage = "image"
+)
+
+// Image understanding endpoint and domain
+const (
+	ImageUnderstandingURL    = "wss://spark-api.cn-huabei-1.xf-yun.com/v2.1/image"
+	ImageUnderstandingDomain = "imagev3"
+)
+
+// MaxImageBytes is the largest image the image understanding API accepts
+const MaxImageBytes = 4 << 20
+
+// ImageMessage returns a user message carrying the image data. Questions
+// about the image follow it as ordinary text messages
+func ImageMessage(data []byte) (SparkMessage, error) {
+	if len(data) == 0 {
+		return SparkMessage{}, newRequestError("image is empty", nil)
+	}
+	if len(data) > MaxImageBytes {
+		return SparkMessage{}, newRequestError(fmt.Sprintf("image of %d bytes exceeds the %d byte limit", len(data), MaxImageBytes), nil)
+	}
+	if mime := http.DetectContentType(data); !strings.HasPrefix(mime, "image/") {
+		return SparkMessage{}, newRequestError(fmt.Sprintf("unsupported image content type %s", mime), nil)
+	}
+	return SparkMessage{
+		Role:        "user",
+		Content:     base64.StdEncoding.EncodeToString(data),
+		ContentType: ContentTypeImage,
+	}, nil
+}
+
+// ImageMessageFromFile returns a user message carrying the image stored at path
+func ImageMessageFromFile(path string) (SparkMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SparkMessage{}, newRequestError("failed to open image", err)
+	}
+	defer f.Close()
+	return readImageMessage(f)
+}
+
+// ImageMessageFromURL downloads the image at url and returns a user message carrying it
+func ImageMessageFromURL(ctx context.Context, url string) (SparkMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return SparkMessage{}, newRequestError("invalid image URL", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return SparkMessage{}, newConnectionError("failed to download image", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return SparkMessage{}, newRequestError(fmt.Sprintf("failed to download image: %s", resp.Status), nil)
+	}
+	return readImageMessage(resp.Body)
+}
+
+// readImageMessage reads at most MaxImageBytes from r into an image message
+func readImageMessage(r io.Reader) (SparkMessage, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxImageBytes+1))
+	if err != nil {
+		return SparkMessage{}, newRequestError("failed to read image", err)
+	}
+	return ImageMessage(data)
+}
@@ -0,0 +1,142 @@
+package gosparkclient
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// pngHeader is enough of a PNG file for content sniffing
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestImageMessage(t *testing.T) {
+	msg, err := ImageMessage(pngHeader)
+	if err != nil {
+		t.Fatalf("ImageMessage failed: %v", err)
+	}
+	if msg.Role != "user" || msg.ContentType != ContentTypeImage || msg.Content != base64.StdEncoding.EncodeToString(pngHeader) {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	if _, err := ImageMessage([]byte("not an image")); err == nil {
+		t.Error("expected error for non-image data")
+	}
+	if _, err := ImageMessage(nil); err == nil {
+		t.Error("expected error for empty image")
+	}
+	big := append(append([]byte(nil), pngHeader...), make([]byte, MaxImageBytes)...)
+	if _, err := ImageMessage(big); err == nil {
+		t.Error("expected error for oversized image")
+	}
+
+	path := filepath.Join(t.TempDir(), "a.png")
+	os.WriteFile(path, pngHeader, 0o644)
+	if msg, err := ImageMessageFromFile(path); err != nil || msg.ContentType != ContentTypeImage {
+		t.Errorf("ImageMessageFromFile = %+v, %v", msg, err)
+	}
+	if _, err := ImageMessageFromFile(filepath.Join(t.TempDir(), "missing.png")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestImageMessageFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(pngHeader)
+	}))
+	defer server.Close()
+
+	if msg, err := ImageMessageFromURL(context.Background(), server.URL+"/a.png"); err != nil || msg.ContentType != ContentTypeImage {
+		t.Errorf("ImageMessageFromURL = %+v, %v", msg, err)
+	}
+	if _, err := ImageMessageFromURL(context.Background(), server.URL+"/missing.png"); err == nil {
+		t.Error("expected error for missing image")
+	}
+}
+
+func TestSparkClient_ChatWithImage(t *testing.T) {
+	var sent SparkAPIRequest
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if err := conn.ReadJSON(&sent); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"一张图片"}]}}}`))
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithImageUnderstanding(),
+		WithURLs(wsURL(server), ""),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	image, err := ImageMessage(pngHeader)
+	if err != nil {
+		t.Fatalf("ImageMessage failed: %v", err)
+	}
+
+	_, err = client.Chat(context.Background(), &SparkChatRequest{Messages: []SparkMessage{
+		image,
+		{Role: "user", Content: "图片里有什么？", ContentType: ContentTypeText},
+	}})
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if sent.Parameter.Chat.Domain != ImageUnderstandingDomain {
+		t.Errorf("domain = %q, want %q", sent.Parameter.Chat.Domain, ImageUnderstandingDomain)
+	}
+	if msgs := sent.Payload.Message.Text; len(msgs) != 2 || msgs[0].ContentType != ContentTypeImage || msgs[1].Content != "图片里有什么？" {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+}
+
+func TestSparkClient_PreflightKeepsImage(t *testing.T) {
+	var sent SparkAPIRequest
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if err := conn.ReadJSON(&sent); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]}}}`))
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithImageUnderstanding(),
+		WithURLs(wsURL(server), ""),
+		WithContextWindow(ImageUnderstandingDomain, 10),
+		WithPreflight(PreflightTruncate),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	image, err := ImageMessage(pngHeader)
+	if err != nil {
+		t.Fatalf("ImageMessage failed: %v", err)
+	}
+
+	_, err = client.Chat(context.Background(), &SparkChatRequest{Messages: []SparkMessage{
+		image,
+		{Role: "user", Content: "describe the picture in great detail please"},
+		{Role: "assistant", Content: "it shows a very small and mostly empty image"},
+		{Role: "user", Content: "what color"},
+	}})
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	msgs := sent.Payload.Message.Text
+	if len(msgs) != 2 || msgs[0].ContentType != ContentTypeImage || msgs[1].Content != "what color" {
+		t.Errorf("expected the image and the last question, got %+v", msgs)
+	}
+}
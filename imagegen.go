@@ -0,0 +1,173 @@
+package gosparkclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ImageGenerationURL is the text-to-image endpoint
+const ImageGenerationURL = "https://spark-api.cn-huabei-1.xf-yun.com/v2.1/tti"
+
+// imageSizes are the width and height combinations the text-to-image API supports
+var imageSizes = map[[2]int]bool{
+	{512, 512}: true, {640, 360}: true, {640, 480}: true, {640, 640}: true,
+	{680, 512}: true, {512, 680}: true, {768, 768}: true, {720, 1280}: true,
+	{1280, 720}: true, {1024, 1024}: true,
+}
+
+// ImageOption configures a GenerateImage call
+type ImageOption func(*imageOptions)
+
+type imageOptions struct {
+	width  int
+	height int
+	domain string
+}
+
+// WithImageSize sets the size of the generated image, 512x512 by default
+func WithImageSize(width, height int) ImageOption {
+	return func(o *imageOptions) {
+		o.width = width
+		o.height = height
+	}
+}
+
+// WithImageDomain sets the image generation domain, "general" by default
+func WithImageDomain(domain string) ImageOption {
+	return func(o *imageOptions) {
+		o.domain = domain
+	}
+}
+
+// SparkImageRequest is the request body of the text-to-image API
+type SparkImageRequest struct {
+	Header struct {
+		AppID string `json:"app_id"`
+		UID   string `json:"uid"`
+	} `json:"header"`
+	Parameter struct {
+		Chat struct {
+			Domain string `json:"domain"`
+			Width  int    `json:"width"`
+			Height int    `json:"height"`
+		} `json:"chat"`
+	} `json:"parameter"`
+	Payload struct {
+		Message struct {
+			Text []SparkMessage `json:"text"`
+		} `json:"message"`
+	} `json:"payload"`
+}
+
+// SparkImageResponse is the response body of the text-to-image API
+type SparkImageResponse struct {
+	Header  SparkHeader `json:"header"`
+	Payload struct {
+		Choices struct {
+			Status int           `json:"status"`
+			Seq    int           `json:"seq"`
+			Text   []SparkChoice `json:"text"`
+		} `json:"choices"`
+	} `json:"payload"`
+}
+
+// GeneratedImage is an image produced by GenerateImage
+type GeneratedImage struct {
+	Data        []byte
+	ContentType string
+	SID         string
+}
+
+// GenerateImage generates an image from prompt. The request is signed like
+// chat requests but sent as an HTTP POST to Config.ImageURL
+func (c *SparkClient) GenerateImage(ctx context.Context, prompt string, opts ...ImageOption) (_ *GeneratedImage, err error) {
+	o := &imageOptions{width: 512, height: 512, domain: "general"}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if !imageSizes[[2]int{o.width, o.height}] {
+		return nil, newRequestError(fmt.Sprintf("unsupported image size %dx%d", o.width, o.height), nil)
+	}
+
+	start := time.Now()
+	var sid string
+	ctx, meter := c.newMeter(ctx, CallImageGeneration, o.domain)
+	defer func() {
+		c.logOutcome(ctx, CallImageGeneration, o.domain, sid, start, nil, err)
+		meter.finish(nil, err)
+	}()
+
+	hostURL := c.config.ImageURL
+	if hostURL == "" {
+		hostURL = ImageGenerationURL
+	}
+	authURL, creds, err := c.assembleAuthURL(ctx, http.MethodPost, hostURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.reportCredentials(creds, nil, err) }()
+
+	req := &SparkImageRequest{}
+	req.Header.AppID = creds.AppID
+	req.Header.UID = c.config.UID
+	req.Parameter.Chat.Domain = o.domain
+	req.Parameter.Chat.Width = o.width
+	req.Parameter.Chat.Height = o.height
+	req.Payload.Message.Text = []SparkMessage{{Role: "user", Content: prompt}}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, newRequestError("failed to encode image request", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, authURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, newRequestError("failed to create image request", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	c.logger.DebugContext(ctx, "spark request sent",
+		"kind", CallImageGeneration,
+		"domain", o.domain,
+		"uid", req.Header.UID,
+		"content", c.logContent(prompt),
+	)
+	ContextClientTrace(ctx).requestSent()
+	httpClient := &http.Client{Transport: c.transport, Timeout: c.config.Timeout}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, newConnectionError("failed to send image request", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, newAuthError("request rejected: "+readBody(resp), nil)
+	}
+	defer resp.Body.Close()
+	ContextClientTrace(ctx).firstFrame()
+	meter.frame()
+
+	var response SparkImageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, newResponseError(fmt.Sprintf("failed to parse image response (HTTP %d)", resp.StatusCode), err)
+	}
+	sid = response.Header.SID
+	if response.Header.Code != 0 {
+		return nil, newHeaderError(response.Header)
+	}
+	if len(response.Payload.Choices.Text) == 0 {
+		return nil, newResponseError("image response contains no image", nil)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(response.Payload.Choices.Text[0].Content)
+	if err != nil {
+		return nil, newResponseError("failed to decode image", err)
+	}
+	return &GeneratedImage{
+		Data:        data,
+		ContentType: http.DetectContentType(data),
+		SID:         sid,
+	}, nil
+}
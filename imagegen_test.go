@@ -0,0 +1,66 @@
+package gosparkclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSparkClient_GenerateImage(t *testing.T) {
+	var got SparkImageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v2.1/tti" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		for _, param := range []string{"host", "date", "authorization"} {
+			if r.URL.Query().Get(param) == "" {
+				t.Errorf("missing signed parameter %q", param)
+			}
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		if got.Payload.Message.Text[0].Content == "违规内容" {
+			fmt.Fprint(w, `{"header":{"code":10021,"message":"input content audit failed","sid":"s2"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"header":{"code":0,"sid":"s1"},"payload":{"choices":{"status":2,"text":[{"content":%q}]}}}`,
+			base64.StdEncoding.EncodeToString(pngHeader))
+	}))
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs("ws://unused", ""),
+		WithImageGenerationURL(server.URL+"/v2.1/tti"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx := context.Background()
+	image, err := client.GenerateImage(ctx, "一只猫", WithImageSize(1024, 1024))
+	if err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	if string(image.Data) != string(pngHeader) || image.ContentType != "image/png" || image.SID != "s1" {
+		t.Errorf("unexpected image %+v", image)
+	}
+	if got.Header.AppID != "app" || got.Parameter.Chat.Width != 1024 || got.Parameter.Chat.Height != 1024 || got.Parameter.Chat.Domain != "general" {
+		t.Errorf("unexpected request %+v", got)
+	}
+
+	_, err = client.GenerateImage(ctx, "违规内容")
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrResponse || sparkErr.Code != 10021 {
+		t.Errorf("expected response error with code 10021, got %v", err)
+	}
+
+	_, err = client.GenerateImage(ctx, "一只猫", WithImageSize(100, 100))
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrRequest {
+		t.Errorf("expected request error for unsupported size, got %v", err)
+	}
+}
@@ -0,0 +1,353 @@
+package gosparkclient
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JSONSchema is the subset of JSON Schema that ChatJSON derives and validates
+type JSONSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Nullable             bool                   `json:"nullable,omitempty"`
+}
+
+// JSONSchemaFor derives a schema from T's JSON encoding. Fields without
+// omitempty and not of pointer type are required; a `jsonschema` struct tag
+// becomes the field's description
+func JSONSchemaFor[T any]() *JSONSchema {
+	return schemaOf(reflect.TypeOf((*T)(nil)).Elem(), map[reflect.Type]bool{})
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage(nil))
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOf derives the schema of t. visiting guards against recursive types,
+// whose inner references are left unconstrained
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) *JSONSchema {
+	if t.Kind() == reflect.Pointer {
+		s := schemaOf(t.Elem(), visiting)
+		s.Nullable = true
+		return s
+	}
+	switch {
+	case t == timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &JSONSchema{}
+	case t.Implements(textMarshalerType):
+		return &JSONSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string", Format: "byte"}
+		}
+		return &JSONSchema{Type: "array", Items: schemaOf(t.Elem(), visiting)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			return &JSONSchema{Type: "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+		addStructFields(s, t, visiting)
+		return s
+	}
+	return &JSONSchema{}
+}
+
+// addStructFields adds the JSON-encoded fields of t, flattening embedded structs
+func addStructFields(s *JSONSchema, t reflect.Type, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructFields(s, ft, visiting)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := schemaOf(f.Type, visiting)
+		prop.Description = f.Tag.Get("jsonschema")
+		s.Properties[name] = prop
+		if !strings.Contains(","+opts+",", ",omitempty,") && f.Type.Kind() != reflect.Pointer {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// Validate checks a value decoded with json.Decoder.UseNumber against the schema
+func (s *JSONSchema) Validate(v any) error {
+	return s.validate("$", v)
+}
+
+func (s *JSONSchema) validate(path string, v any) error {
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: must be %s, got null", path, s.Type)
+	}
+
+	switch s.Type {
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: must be a string", path)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: must be a boolean", path)
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: must be a %s", path, s.Type)
+		}
+		if s.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				return fmt.Errorf("%s: must be an integer, got %s", path, n)
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an array", path)
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required field %q", path, name)
+			}
+		}
+		for name, value := range obj {
+			prop := s.Properties[name]
+			if prop == nil {
+				prop = s.AdditionalProperties
+			}
+			if prop == nil {
+				continue
+			}
+			if err := prop.validate(path+"."+name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// JSONOption configures ChatJSON
+type JSONOption func(*jsonOptions)
+
+type jsonOptions struct {
+	maxRepairs  int
+	schema      *JSONSchema
+	requestOpts []RequestOption
+}
+
+// WithMaxRepairs sets how many times ChatJSON re-prompts after an invalid reply, 2 by default
+func WithMaxRepairs(n int) JSONOption {
+	return func(o *jsonOptions) {
+		o.maxRepairs = n
+	}
+}
+
+// WithJSONSchema replaces the schema derived from the result type
+func WithJSONSchema(schema *JSONSchema) JSONOption {
+	return func(o *jsonOptions) {
+		o.schema = schema
+	}
+}
+
+// WithJSONRequestOptions applies request options, such as WithRequestUID,
+// to every chat ChatJSON makes, repairs included
+func WithJSONRequestOptions(opts ...RequestOption) JSONOption {
+	return func(o *jsonOptions) {
+		o.requestOpts = append(o.requestOpts, opts...)
+	}
+}
+
+// ChatJSON asks the model to answer req with JSON matching T's schema and
+// decodes the reply. Replies that cannot be extracted, do not match the schema
+// or fail T's Validate method, if it has one, are sent back to the model with
+// the error for repair
+func ChatJSON[T any](ctx context.Context, client *SparkClient, req *SparkChatRequest, opts ...JSONOption) (T, error) {
+	var zero T
+
+	o := &jsonOptions{maxRepairs: 2}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.schema == nil {
+		o.schema = JSONSchemaFor[T]()
+	}
+	schema, err := json.MarshalIndent(o.schema, "", "  ")
+	if err != nil {
+		return zero, newRequestError("failed to encode JSON schema", err)
+	}
+
+	r := *req
+	r.Messages = append([]SparkMessage(nil), req.Messages...)
+	instruction := "请只输出符合以下 JSON Schema 的 JSON，不要输出任何其他内容：\n" + string(schema)
+	if r.System != "" {
+		r.System += "\n\n" + instruction
+	} else {
+		r.System = instruction
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= o.maxRepairs; attempt++ {
+		resp, err := client.Chat(ctx, &r, o.requestOpts...)
+		if err != nil {
+			return zero, err
+		}
+		reply := ""
+		if len(resp.Payload.Choices.Text) > 0 {
+			reply = resp.Payload.Choices.Text[0].Content
+		}
+
+		result, err := decodeJSONReply[T](reply, o.schema)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+		r.Messages = append(r.Messages,
+			SparkMessage{Role: "assistant", Content: reply},
+			SparkMessage{Role: "user", Content: "你的回复不符合要求：" + err.Error() + "。请只输出修正后的 JSON。"})
+	}
+	return zero, newResponseError(fmt.Sprintf("no valid JSON after %d attempts", o.maxRepairs+1), lastErr)
+}
+
+// decodeJSONReply extracts, validates and decodes the JSON in reply
+func decodeJSONReply[T any](reply string, schema *JSONSchema) (T, error) {
+	var result T
+
+	data, ok := extractJSON(reply)
+	if !ok {
+		return result, fmt.Errorf("no JSON found in reply")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return result, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := schema.Validate(generic); err != nil {
+		return result, err
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if v, ok := any(&result).(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// extractJSON returns the first JSON object or array in text, preferring the
+// contents of a fenced code block
+func extractJSON(text string) ([]byte, bool) {
+	if start := strings.Index(text, "```"); start >= 0 {
+		body := text[start+3:]
+		if nl := strings.IndexByte(body, '\n'); nl >= 0 {
+			body = body[nl+1:] // skip the language tag
+		}
+		if end := strings.Index(body, "```"); end >= 0 {
+			if data, ok := balancedJSON(body[:end]); ok {
+				return data, true
+			}
+		}
+	}
+	return balancedJSON(text)
+}
+
+// balancedJSON returns the first balanced {...} or [...] span in text,
+// ignoring brackets inside strings
+func balancedJSON(text string) ([]byte, bool) {
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return nil, false
+	}
+
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if ch == '\\' {
+				escaped = true
+			} else if ch == '"' {
+				inString = false
+			}
+		case ch == '"':
+			inString = true
+		case ch == '{' || ch == '[':
+			depth++
+		case ch == '}' || ch == ']':
+			depth--
+			if depth == 0 {
+				return []byte(text[start : i+1]), true
+			}
+		}
+	}
+	return nil, false
+}
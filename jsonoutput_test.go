@@ -0,0 +1,169 @@
+package gosparkclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+type jsonTestAddress struct {
+	City string `json:"city"`
+}
+
+type jsonTestPerson struct {
+	Name    string            `json:"name" jsonschema:"full name"`
+	Age     int               `json:"age"`
+	Tags    []string          `json:"tags,omitempty"`
+	Address *jsonTestAddress  `json:"address"`
+	Extra   map[string]string `json:"extra,omitempty"`
+	secret  string
+}
+
+func (p *jsonTestPerson) Validate() error {
+	if p.Age < 0 {
+		return errors.New("age must not be negative")
+	}
+	return nil
+}
+
+func TestJSONSchemaFor(t *testing.T) {
+	schema := JSONSchemaFor[jsonTestPerson]()
+	if schema.Type != "object" {
+		t.Fatalf("Type = %q, want object", schema.Type)
+	}
+	if want := []string{"name", "age"}; !reflect.DeepEqual(schema.Required, want) {
+		t.Errorf("Required = %v, want %v", schema.Required, want)
+	}
+	if got := schema.Properties["name"].Description; got != "full name" {
+		t.Errorf("name description = %q", got)
+	}
+	if got := schema.Properties["tags"]; got.Type != "array" || got.Items.Type != "string" {
+		t.Errorf("unexpected tags schema: %+v", got)
+	}
+	if got := schema.Properties["address"]; !got.Nullable || got.Properties["city"].Type != "string" {
+		t.Errorf("unexpected address schema: %+v", got)
+	}
+	if _, ok := schema.Properties["secret"]; ok {
+		t.Error("unexported field must not be in the schema")
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"plain", `{"a":1}`, `{"a":1}`},
+		{"surrounded", `结果如下：{"a":"}"} 希望有帮助`, `{"a":"}"}`},
+		{"fenced", "说明 {x}\n```json\n[1, 2]\n```", `[1, 2]`},
+		{"nested", `{"a":{"b":[1]}} {"c":2}`, `{"a":{"b":[1]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractJSON(tt.text)
+			if !ok || string(got) != tt.want {
+				t.Errorf("extractJSON = %q, %v; want %q", got, ok, tt.want)
+			}
+		})
+	}
+	if _, ok := extractJSON("没有 JSON"); ok {
+		t.Error("expected no JSON")
+	}
+}
+
+// newScriptedServer answers each chat request with the next reply, recording the requests
+func newScriptedServer(t *testing.T, replies ...string) (string, func() []SparkAPIRequest, func()) {
+	var mu sync.Mutex
+	var requests []SparkAPIRequest
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		mu.Lock()
+		reply := replies[len(requests)%len(replies)]
+		requests = append(requests, req)
+		mu.Unlock()
+
+		content, _ := json.Marshal(reply)
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":`+string(content)+`}]}}}`))
+	})
+	recorded := func() []SparkAPIRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]SparkAPIRequest(nil), requests...)
+	}
+	return wsURL(server), recorded, server.Close
+}
+
+func TestChatJSON(t *testing.T) {
+	url, requests, closeServer := newScriptedServer(t,
+		"好的：```json\n{\"name\": \"张三\"}\n```",
+		`{"name": "张三", "age": -1, "address": null}`,
+		`{"name": "张三", "age": 30, "address": {"city": "合肥"}}`,
+	)
+	defer closeServer()
+
+	client, err := NewSparkClient(WithCredentials("app", "key", "secret"), WithURLs(url, ""))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	req := &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: "介绍张三"}}}
+	person, err := ChatJSON[jsonTestPerson](context.Background(), client, req, WithJSONRequestOptions(WithRequestUID("user-7")))
+	if err != nil {
+		t.Fatalf("ChatJSON failed: %v", err)
+	}
+	if person.Name != "张三" || person.Age != 30 || person.Address == nil || person.Address.City != "合肥" {
+		t.Errorf("unexpected result: %+v", person)
+	}
+
+	sent := requests()
+	if len(sent) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(sent))
+	}
+	for i, r := range sent {
+		if r.Header.UID != "user-7" {
+			t.Errorf("attempt %d uid = %q, want user-7", i, r.Header.UID)
+		}
+	}
+	if system := sent[0].Payload.Message.Text[0]; system.Role != "system" || !strings.Contains(system.Content, `"age"`) {
+		t.Errorf("expected schema instructions in the system prompt, got %+v", system)
+	}
+	repair := sent[1].Payload.Message.Text
+	if last := repair[len(repair)-1]; !strings.Contains(last.Content, `missing required field "age"`) {
+		t.Errorf("expected validation error in repair prompt, got %q", last.Content)
+	}
+	if last := sent[2].Payload.Message.Text; !strings.Contains(last[len(last)-1].Content, "age must not be negative") {
+		t.Errorf("expected Validate error in repair prompt, got %q", last[len(last)-1].Content)
+	}
+	if len(req.Messages) != 1 || req.System != "" {
+		t.Error("caller's request must not be modified")
+	}
+}
+
+func TestChatJSON_GivesUp(t *testing.T) {
+	url, requests, closeServer := newScriptedServer(t, "我不知道")
+	defer closeServer()
+
+	client, err := NewSparkClient(WithCredentials("app", "key", "secret"), WithURLs(url, ""))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	_, err = ChatJSON[[]int](context.Background(), client, &SparkChatRequest{
+		Messages: []SparkMessage{{Role: "user", Content: "列出质数"}},
+	}, WithMaxRepairs(1))
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrResponse {
+		t.Fatalf("expected response error, got %v", err)
+	}
+	if n := len(requests()); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+}
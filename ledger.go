@@ -0,0 +1,350 @@
+package gosparkclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a context that attributes calls made with it to tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant tag set by WithTenant, or ""
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// ModelPrice is the price of a model per 1,000 tokens
+type ModelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// PriceTable maps a domain to its price
+type PriceTable map[string]ModelPrice
+
+// Cost returns the price of usage on domain, or 0 when the domain is not priced
+func (t PriceTable) Cost(domain string, usage SparkUsage) float64 {
+	price, ok := t[domain]
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1000
+}
+
+// UsageRecord is the token usage of a single chat call
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	Tenant           string    `json:"tenant,omitempty"`
+	UID              string    `json:"uid"`
+	Domain           string    `json:"domain"`
+	SID              string    `json:"sid,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	Cost             float64   `json:"cost"`
+}
+
+// UsageFilter selects usage records. Empty fields match everything; From is
+// inclusive and To is exclusive
+type UsageFilter struct {
+	Tenant string
+	UID    string
+	Domain string
+	From   time.Time
+	To     time.Time
+}
+
+// Match reports whether rec is selected by the filter
+func (f UsageFilter) Match(rec UsageRecord) bool {
+	if f.Tenant != "" && rec.Tenant != f.Tenant {
+		return false
+	}
+	if f.UID != "" && rec.UID != f.UID {
+		return false
+	}
+	if f.Domain != "" && rec.Domain != f.Domain {
+		return false
+	}
+	if !f.From.IsZero() && rec.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !rec.Time.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// UsageStore persists usage records
+type UsageStore interface {
+	Append(ctx context.Context, rec UsageRecord) error
+	Query(ctx context.Context, filter UsageFilter) ([]UsageRecord, error)
+}
+
+// MemoryUsageStore keeps usage records in memory
+type MemoryUsageStore struct {
+	mu      sync.RWMutex
+	records []UsageRecord
+}
+
+// NewMemoryUsageStore creates an empty in-memory store
+func NewMemoryUsageStore() *MemoryUsageStore {
+	return &MemoryUsageStore{}
+}
+
+// Append adds rec to the store
+func (s *MemoryUsageStore) Append(ctx context.Context, rec UsageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+	return nil
+}
+
+// Query returns the records matching filter
+func (s *MemoryUsageStore) Query(ctx context.Context, filter UsageFilter) ([]UsageRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []UsageRecord
+	for _, rec := range s.records {
+		if filter.Match(rec) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// JSONLUsageStore appends usage records to a file, one JSON object per line
+type JSONLUsageStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewJSONLUsageStore creates a store backed by the file at path
+func NewJSONLUsageStore(path string) *JSONLUsageStore {
+	return &JSONLUsageStore{path: path}
+}
+
+// Append writes rec as a new line
+func (s *JSONLUsageStore) Append(ctx context.Context, rec UsageRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Query scans the file for records matching filter
+func (s *JSONLUsageStore) Query(ctx context.Context, filter UsageFilter) ([]UsageRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []UsageRecord
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", s.path, line, err)
+		}
+		if filter.Match(rec) {
+			out = append(out, rec)
+		}
+	}
+	return out, scanner.Err()
+}
+
+// UsageSummary aggregates usage for one period, tenant and domain
+type UsageSummary struct {
+	Period           string
+	Tenant           string
+	Domain           string
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	Cost             float64
+}
+
+// UsageLedger records the token usage and cost of every chat call
+type UsageLedger struct {
+	store    UsageStore
+	prices   PriceTable
+	location *time.Location
+	onError  func(error)
+	now      func() time.Time
+}
+
+// LedgerOption configures a UsageLedger
+type LedgerOption func(*UsageLedger)
+
+// WithPriceTable sets the prices used to compute the cost of each call
+func WithPriceTable(prices PriceTable) LedgerOption {
+	return func(l *UsageLedger) {
+		l.prices = prices
+	}
+}
+
+// WithLedgerLocation sets the time zone that daily and monthly periods are computed in
+func WithLedgerLocation(loc *time.Location) LedgerOption {
+	return func(l *UsageLedger) {
+		l.location = loc
+	}
+}
+
+// WithLedgerErrorHandler sets a function called when a record cannot be stored.
+// Storage errors never fail the call itself
+func WithLedgerErrorHandler(handler func(error)) LedgerOption {
+	return func(l *UsageLedger) {
+		l.onError = handler
+	}
+}
+
+// NewUsageLedger creates a ledger writing to store
+func NewUsageLedger(store UsageStore, opts ...LedgerOption) *UsageLedger {
+	l := &UsageLedger{
+		store:    store,
+		location: time.Local,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Record stores the usage of a call, computing its cost from the price table
+func (l *UsageLedger) Record(ctx context.Context, rec UsageRecord) error {
+	if rec.Time.IsZero() {
+		rec.Time = l.now()
+	}
+	rec.Cost = l.prices.Cost(rec.Domain, SparkUsage{
+		PromptTokens:     rec.PromptTokens,
+		CompletionTokens: rec.CompletionTokens,
+	})
+	return l.store.Append(ctx, rec)
+}
+
+// Middleware returns middleware that records the usage of every successful chat call
+func (l *UsageLedger) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*Result, error) {
+			if call.Kind == CallEmbedding {
+				return next(ctx, call)
+			}
+
+			var uid string
+			onRequest := call.OnRequest
+			call.OnRequest = func(apiReq *SparkAPIRequest) {
+				uid = apiReq.Header.UID
+				if onRequest != nil {
+					onRequest(apiReq)
+				}
+			}
+
+			result, err := next(ctx, call)
+			if err != nil || result == nil || result.Response == nil {
+				return result, err
+			}
+
+			resp := result.Response
+			usage := resp.Payload.Usage.Text
+			recErr := l.Record(ctx, UsageRecord{
+				Tenant:           TenantFromContext(ctx),
+				UID:              uid,
+				Domain:           resp.Model.Domain,
+				SID:              resp.Header.SID,
+				PromptTokens:     usage.PromptTokens,
+				CompletionTokens: usage.CompletionTokens,
+				TotalTokens:      usage.TotalTokens,
+			})
+			if recErr != nil && l.onError != nil {
+				l.onError(recErr)
+			}
+			return result, nil
+		}
+	}
+}
+
+// DailySummary aggregates matching usage per day, tenant and domain
+func (l *UsageLedger) DailySummary(ctx context.Context, filter UsageFilter) ([]UsageSummary, error) {
+	return l.summarize(ctx, filter, "2006-01-02")
+}
+
+// MonthlySummary aggregates matching usage per month, tenant and domain
+func (l *UsageLedger) MonthlySummary(ctx context.Context, filter UsageFilter) ([]UsageSummary, error) {
+	return l.summarize(ctx, filter, "2006-01")
+}
+
+// summarize groups records by their time formatted with layout
+func (l *UsageLedger) summarize(ctx context.Context, filter UsageFilter, layout string) ([]UsageSummary, error) {
+	records, err := l.store.Query(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ period, tenant, domain string }
+	groups := map[key]*UsageSummary{}
+	for _, rec := range records {
+		k := key{rec.Time.In(l.location).Format(layout), rec.Tenant, rec.Domain}
+		sum, ok := groups[k]
+		if !ok {
+			sum = &UsageSummary{Period: k.period, Tenant: k.tenant, Domain: k.domain}
+			groups[k] = sum
+		}
+		sum.Requests++
+		sum.PromptTokens += rec.PromptTokens
+		sum.CompletionTokens += rec.CompletionTokens
+		sum.TotalTokens += rec.TotalTokens
+		sum.Cost += rec.Cost
+	}
+
+	out := make([]UsageSummary, 0, len(groups))
+	for _, sum := range groups {
+		out = append(out, *sum)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Period != out[j].Period {
+			return out[i].Period < out[j].Period
+		}
+		if out[i].Tenant != out[j].Tenant {
+			return out[i].Tenant < out[j].Tenant
+		}
+		return out[i].Domain < out[j].Domain
+	})
+	return out, nil
+}
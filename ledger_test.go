@@ -0,0 +1,103 @@
+package gosparkclient
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageLedger_Middleware(t *testing.T) {
+	server := newMockServer(t,
+		`{"header":{"code":0,"sid":"sid-1"},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]},"usage":{"text":{"prompt_tokens":1000,"completion_tokens":500,"total_tokens":1500}}}}`,
+	)
+	defer server.Close()
+
+	store := NewMemoryUsageStore()
+	ledger := NewUsageLedger(store, WithPriceTable(PriceTable{
+		"lite": {Prompt: 0.5, Completion: 1},
+	}))
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+		WithDomain("lite"),
+		WithUID("user-7"),
+		WithUsageLedger(ledger),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx := WithTenant(context.Background(), "acme")
+	if _, err := client.ChatSimple(ctx, "hi"); err != nil {
+		t.Fatalf("ChatSimple failed: %v", err)
+	}
+
+	records, _ := store.Query(context.Background(), UsageFilter{Tenant: "acme"})
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	rec := records[0]
+	if rec.UID != "user-7" || rec.Domain != "lite" || rec.SID != "sid-1" || rec.TotalTokens != 1500 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if math.Abs(rec.Cost-1.0) > 1e-9 {
+		t.Errorf("cost = %v, want 1.0", rec.Cost)
+	}
+}
+
+func TestUsageLedger_Summaries(t *testing.T) {
+	store := NewJSONLUsageStore(filepath.Join(t.TempDir(), "usage.jsonl"))
+	ledger := NewUsageLedger(store, WithLedgerLocation(time.UTC), WithPriceTable(PriceTable{
+		"generalv3.5": {Prompt: 1, Completion: 2},
+	}))
+
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 12, 0, 0, 0, time.UTC) }
+	records := []UsageRecord{
+		{Time: day(1), Tenant: "acme", Domain: "generalv3.5", PromptTokens: 1000, CompletionTokens: 1000, TotalTokens: 2000},
+		{Time: day(1), Tenant: "acme", Domain: "generalv3.5", PromptTokens: 500, CompletionTokens: 0, TotalTokens: 500},
+		{Time: day(2), Tenant: "acme", Domain: "generalv3.5", PromptTokens: 100, CompletionTokens: 100, TotalTokens: 200},
+		{Time: day(2), Tenant: "other", Domain: "lite", PromptTokens: 10, CompletionTokens: 10, TotalTokens: 20},
+		{Time: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), Tenant: "acme", Domain: "generalv3.5", TotalTokens: 1},
+	}
+	for _, rec := range records {
+		if err := ledger.Record(ctx, rec); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	daily, err := ledger.DailySummary(ctx, UsageFilter{Tenant: "acme", From: day(1), To: day(3)})
+	if err != nil {
+		t.Fatalf("DailySummary failed: %v", err)
+	}
+	if len(daily) != 2 {
+		t.Fatalf("expected 2 daily summaries, got %+v", daily)
+	}
+	if daily[0].Period != "2024-03-01" || daily[0].Requests != 2 || daily[0].TotalTokens != 2500 || math.Abs(daily[0].Cost-3.5) > 1e-9 {
+		t.Errorf("unexpected summary for 2024-03-01: %+v", daily[0])
+	}
+
+	monthly, err := ledger.MonthlySummary(ctx, UsageFilter{})
+	if err != nil {
+		t.Fatalf("MonthlySummary failed: %v", err)
+	}
+	want := []struct {
+		period, tenant string
+		requests       int
+	}{
+		{"2024-03", "acme", 3},
+		{"2024-03", "other", 1},
+		{"2024-04", "acme", 1},
+	}
+	if len(monthly) != len(want) {
+		t.Fatalf("expected %d monthly summaries, got %+v", len(want), monthly)
+	}
+	for i, w := range want {
+		if monthly[i].Period != w.period || monthly[i].Tenant != w.tenant || monthly[i].Requests != w.requests {
+			t.Errorf("monthly[%d] = %+v, want %+v", i, monthly[i], w)
+		}
+	}
+}
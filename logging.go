@@ -0,0 +1,163 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// signedParamPattern matches signature material in signed URLs and headers
+var signedParamPattern = regexp.MustCompile(`(?i)(authorization|signature)=[^&\s"]+`)
+
+// sensitiveLogKeys are attribute keys whose values are never logged
+var sensitiveLogKeys = map[string]bool{
+	"api_secret":    true,
+	"apisecret":     true,
+	"secret":        true,
+	"authorization": true,
+	"signature":     true,
+}
+
+// LogValue implements slog.LogValuer so credentials never leak their secret into logs
+func (c Credentials) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("app_id", c.AppID),
+		slog.String("api_key", c.APIKey),
+		slog.String("api_secret", redacted),
+	)
+}
+
+// String implements fmt.Stringer with the secret redacted
+func (c Credentials) String() string {
+	return "{AppID:" + c.AppID + " APIKey:" + c.APIKey + " APISecret:" + redacted + "}"
+}
+
+// newLogger wraps the configured logger so that every record is scrubbed of
+// secrets. A nil logger disables logging
+func newLogger(config *Config) *slog.Logger {
+	if config.Logger == nil {
+		return slog.New(discardHandler{})
+	}
+	return slog.New(redactingHandler{config.Logger.Handler()})
+}
+
+// logContent returns content for logging, or a placeholder unless content logging is enabled
+func (c *SparkClient) logContent(content string) string {
+	if c.config.LogContent {
+		return content
+	}
+	return redacted
+}
+
+// logOutcome emits the finish or error event for a single request
+func (c *SparkClient) logOutcome(ctx context.Context, kind CallKind, domain, sid string, start time.Time, usage *SparkUsage, err error) {
+	attrs := []any{
+		"kind", kind,
+		"domain", domain,
+		"sid", sid,
+		"latency", time.Since(start),
+	}
+
+	if err != nil {
+		var sparkErr *SparkError
+		if errors.As(err, &sparkErr) {
+			attrs = append(attrs, "error_type", sparkErr.Type, "code", sparkErr.Code)
+		}
+		c.logger.ErrorContext(ctx, "spark request failed", append(attrs, "error", err)...)
+		return
+	}
+
+	if usage != nil {
+		attrs = append(attrs,
+			"prompt_tokens", usage.PromptTokens,
+			"completion_tokens", usage.CompletionTokens,
+			"total_tokens", usage.TotalTokens,
+		)
+	}
+	c.logger.InfoContext(ctx, "spark request finished", attrs...)
+}
+
+// lastMessageContent returns the content of the last message, usually the user's question
+func lastMessageContent(messages []SparkMessage) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	last := messages[len(messages)-1]
+	if last.ContentType == ContentTypeImage {
+		return "[image]"
+	}
+	return last.Content
+}
+
+// redactString removes signature material from s
+func redactString(s string) string {
+	return signedParamPattern.ReplaceAllString(s, "$1="+redacted)
+}
+
+// redactAttr scrubs a single attribute, descending into groups
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if sensitiveLogKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(a.Value.String()))
+	case slog.KindGroup:
+		attrs := a.Value.Group()
+		clean := make([]any, 0, len(attrs))
+		for _, attr := range attrs {
+			clean = append(clean, redactAttr(attr))
+		}
+		return slog.Group(a.Key, clean...)
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, redactString(err.Error()))
+		}
+	}
+	return a
+}
+
+// redactingHandler scrubs secrets from every record before passing it on
+type redactingHandler struct {
+	handler slog.Handler
+}
+
+func (h redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	clean := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.handler.Handle(ctx, clean)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		clean = append(clean, redactAttr(a))
+	}
+	return redactingHandler{h.handler.WithAttrs(clean)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.handler.WithGroup(name)}
+}
+
+// discardHandler drops every record
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,82 @@
+package gosparkclient
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSparkClient_Logging(t *testing.T) {
+	server := newMockServer(t,
+		`{"header":{"code":0,"sid":"sid-1"},"payload":{"choices":{"status":1,"seq":0,"text":[{"content":"private "}]}}}`,
+		`{"header":{"code":0,"sid":"sid-1"},"payload":{"choices":{"status":2,"seq":1,"text":[{"content":"answer"}]},"usage":{"text":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}}}`,
+	)
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		logContent  bool
+		wantContent bool
+	}{
+		{name: "content redacted by default", logContent: false, wantContent: false},
+		{name: "content logging enabled", logContent: true, wantContent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+			client, err := NewSparkClient(
+				WithCredentials("app", "key", "top-secret"),
+				WithURLs(wsURL(server), ""),
+				WithDomain("lite"),
+				WithLogger(logger),
+				WithContentLogging(tt.logContent),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			if _, err := client.ChatSimple(context.Background(), "my private question"); err != nil {
+				t.Fatalf("ChatSimple failed: %v", err)
+			}
+
+			out := buf.String()
+			for _, event := range []string{"spark dial", "spark handshake", "spark request sent", "spark frame received", "spark request finished"} {
+				if !strings.Contains(out, event) {
+					t.Errorf("missing %q event in log output", event)
+				}
+			}
+			if !strings.Contains(out, `"sid":"sid-1"`) || !strings.Contains(out, `"total_tokens":7`) {
+				t.Errorf("finish event lacks sid or token usage:\n%s", out)
+			}
+			if strings.Contains(out, "top-secret") || strings.Contains(out, "authorization=") {
+				t.Errorf("log output leaks signing material:\n%s", out)
+			}
+			if got := strings.Contains(out, "my private question"); got != tt.wantContent {
+				t.Errorf("content present = %v, want %v:\n%s", got, tt.wantContent, out)
+			}
+		})
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&Config{Logger: slog.New(slog.NewTextHandler(&buf, nil))})
+
+	logger.Info("dial wss://host/v1?authorization=abc123&date=now",
+		"creds", Credentials{AppID: "app", APIKey: "key", APISecret: "s3cr3t"},
+		"api_secret", "s3cr3t",
+		slog.Group("req", "url", "wss://host/v1?host=h&authorization=abc123"),
+	)
+
+	out := buf.String()
+	if strings.Contains(out, "s3cr3t") || strings.Contains(out, "abc123") {
+		t.Errorf("secrets leaked: %s", out)
+	}
+	if !strings.Contains(out, "app_id=app") {
+		t.Errorf("expected non-secret fields to be kept: %s", out)
+	}
+}
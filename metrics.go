@@ -0,0 +1,106 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RequestMetrics holds the measurements of a single request to a Spark endpoint.
+// With a fallback policy every attempted model produces its own RequestMetrics
+type RequestMetrics struct {
+	Kind   CallKind
+	Domain string
+
+	// ErrorType and ErrorCode are empty when the request succeeded
+	ErrorType ErrorType
+	ErrorCode int
+
+	DialDuration     time.Duration
+	TimeToFirstFrame time.Duration
+	Duration         time.Duration
+	Frames           int
+
+	// Usage is the token usage reported on the final chat frame
+	Usage SparkUsage
+}
+
+// MetricsHook receives the measurements of every request made by a client.
+// ObserveRequest may be called concurrently and must not block
+type MetricsHook interface {
+	ObserveRequest(m RequestMetrics)
+}
+
+// MetricsHookFunc adapts an ordinary function to the MetricsHook interface
+type MetricsHookFunc func(m RequestMetrics)
+
+// ObserveRequest calls f(m)
+func (f MetricsHookFunc) ObserveRequest(m RequestMetrics) {
+	f(m)
+}
+
+// requestMeter collects RequestMetrics for one request. A nil meter records nothing
+type requestMeter struct {
+	hook      MetricsHook
+	metrics   RequestMetrics
+	start     time.Time
+	dialStart time.Time
+	sent      time.Time
+}
+
+// newMeter starts measuring a request and returns a context whose ClientTrace
+// feeds the meter. It returns ctx unchanged when no MetricsHook is configured
+func (c *SparkClient) newMeter(ctx context.Context, kind CallKind, domain string) (context.Context, *requestMeter) {
+	if c.config.Metrics == nil {
+		return ctx, nil
+	}
+
+	m := &requestMeter{
+		hook:    c.config.Metrics,
+		metrics: RequestMetrics{Kind: kind, Domain: domain},
+		start:   time.Now(),
+	}
+	ctx = WithClientTrace(ctx, &ClientTrace{
+		DialStart: func(string) {
+			m.dialStart = time.Now()
+		},
+		DialDone: func(string, error) {
+			m.metrics.DialDuration = time.Since(m.dialStart)
+		},
+		RequestSent: func() {
+			m.sent = time.Now()
+		},
+		FirstFrame: func() {
+			m.metrics.TimeToFirstFrame = time.Since(m.sent)
+		},
+	})
+	return ctx, m
+}
+
+// frame counts a received response frame
+func (m *requestMeter) frame() {
+	if m != nil {
+		m.metrics.Frames++
+	}
+}
+
+// finish reports the request's metrics to the hook
+func (m *requestMeter) finish(usage *SparkUsage, err error) {
+	if m == nil {
+		return
+	}
+
+	m.metrics.Duration = time.Since(m.start)
+	if usage != nil {
+		m.metrics.Usage = *usage
+	}
+	if err != nil {
+		m.metrics.ErrorType = ErrRequest
+		var sparkErr *SparkError
+		if errors.As(err, &sparkErr) {
+			m.metrics.ErrorType = sparkErr.Type
+			m.metrics.ErrorCode = sparkErr.Code
+		}
+	}
+	m.hook.ObserveRequest(m.metrics)
+}
@@ -0,0 +1,57 @@
+package gosparkclient
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestSparkClient_MetricsHook(t *testing.T) {
+	url, closeServer := newDomainServer(t, map[string][]string{
+		"4.0Ultra": {`{"header":{"code":10110,"message":"service busy"}}`},
+		"lite": {
+			`{"header":{"code":0},"payload":{"choices":{"status":1,"text":[{"content":"a"}]}}}`,
+			`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"b"}]},"usage":{"text":{"question_tokens":2,"prompt_tokens":6,"completion_tokens":3,"total_tokens":9}}}}`,
+		},
+	})
+	defer closeServer()
+
+	var mu sync.Mutex
+	var observed []RequestMetrics
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(url, ""),
+		WithDomain("4.0Ultra"),
+		WithFallback(DefaultFallbackPolicy(ModelTarget{HostURL: url, Domain: "lite"})),
+		WithMetrics(MetricsHookFunc(func(m RequestMetrics) {
+			mu.Lock()
+			defer mu.Unlock()
+			observed = append(observed, m)
+		})),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if _, err := client.ChatSimple(context.Background(), "hi"); err != nil {
+		t.Fatalf("ChatSimple failed: %v", err)
+	}
+
+	if len(observed) != 2 {
+		t.Fatalf("expected metrics for 2 attempts, got %d", len(observed))
+	}
+
+	failed, succeeded := observed[0], observed[1]
+	if failed.Domain != "4.0Ultra" || failed.ErrorType != ErrResponse || failed.ErrorCode != CodeServiceBusy {
+		t.Errorf("unexpected metrics for failed attempt: %+v", failed)
+	}
+	if succeeded.Domain != "lite" || succeeded.ErrorType != "" || succeeded.Frames != 2 {
+		t.Errorf("unexpected metrics for successful attempt: %+v", succeeded)
+	}
+	if succeeded.Usage.CompletionTokens != 3 || succeeded.Usage.QuestionTokens != 2 {
+		t.Errorf("unexpected usage: %+v", succeeded.Usage)
+	}
+	if succeeded.DialDuration <= 0 || succeeded.Duration < succeeded.DialDuration {
+		t.Errorf("unexpected timings: %+v", succeeded)
+	}
+}
@@ -0,0 +1,85 @@
+package gosparkclient
+
+import "context"
+
+// CallKind identifies the client method that started a call
+type CallKind string
+
+const (
+	CallChat       CallKind = "chat"
+	CallChatStream CallKind = "chat_stream"
+	CallEmbedding  CallKind = "embedding"
+
+	// CallImageGeneration is reported to logs and metrics only; GenerateImage
+	// does not run through the middleware chain
+	CallImageGeneration CallKind = "image_generation"
+
+	// CallDocumentChat is reported to logs and metrics only; AskDocuments
+	// does not run through the middleware chain
+	CallDocumentChat CallKind = "document_chat"
+)
+
+// Call describes a single client call as it flows through the middleware chain.
+// Middleware may replace Request or wrap the hooks to observe or alter each stage
+type Call struct {
+	Kind CallKind
+
+	// Request is set for chat calls
+	Request *SparkChatRequest
+
+	// Model is the primary model of a chat call. Fallback models, if any,
+	// are tried after it
+	Model ModelTarget
+
+	// Query and EmbeddingDomain are set for embedding calls
+	Query           string
+	EmbeddingDomain string
+
+	// UID and Auditing are the end user and moderation mode the call is
+	// made for. When empty the client's configuration applies
+	UID      string
+	Auditing string
+
+	// PatchIDs are the fine-tuning patches applied to Model. They are not
+	// sent to fallback models
+	PatchIDs []string
+
+	// OnRequest is called with the assembled chat request before it is sent.
+	// With a fallback policy it is called once per attempted model
+	OnRequest func(apiReq *SparkAPIRequest)
+
+	// OnEmbeddingRequest is called with the assembled embedding request before it is sent
+	OnEmbeddingRequest func(embReq *SparkAPIEmbRequest)
+
+	// OnFrame is called for every streamed chat frame
+	OnFrame ChatCallback
+
+	// Estimator is the client's token estimator, for middleware that needs
+	// usage estimates before Spark reports them
+	Estimator *TokenEstimator
+}
+
+// Result is the outcome of a call
+type Result struct {
+	// Response is the final chat frame with the content of all frames joined
+	Response *SparkAPIResponse
+
+	// Embedding is the embedding response
+	Embedding *SparkAPIEmbResponse
+}
+
+// Handler executes a call
+type Handler func(ctx context.Context, call *Call) (*Result, error)
+
+// Middleware wraps a Handler with cross-cutting behaviour such as logging or caching
+type Middleware func(next Handler) Handler
+
+// do runs call through the configured middleware, outermost first
+func (c *SparkClient) do(ctx context.Context, call *Call) (*Result, error) {
+	handler := Handler(c.send)
+	for i := len(c.config.Middlewares) - 1; i >= 0; i-- {
+		handler = c.config.Middlewares[i](handler)
+	}
+	call.Estimator = c.estimator()
+	return handler(ctx, call)
+}
@@ -0,0 +1,127 @@
+package gosparkclient
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSparkClient_MiddlewareChain(t *testing.T) {
+	var sent SparkAPIRequest
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if err := conn.ReadJSON(&sent); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":1,"text":[{"content":"Hello "}]}}}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"world"}]}}}`))
+	})
+	defer server.Close()
+
+	var events []string
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, call *Call) (*Result, error) {
+				events = append(events, name+":request:"+call.Request.Messages[0].Content)
+
+				onRequest := call.OnRequest
+				call.OnRequest = func(apiReq *SparkAPIRequest) {
+					events = append(events, name+":assembled:"+apiReq.Parameter.Chat.Domain)
+					if onRequest != nil {
+						onRequest(apiReq)
+					}
+				}
+
+				onFrame := call.OnFrame
+				call.OnFrame = func(resp *SparkAPIResponse) {
+					events = append(events, name+":frame:"+resp.Payload.Choices.Text[0].Content)
+					if onFrame != nil {
+						onFrame(resp)
+					}
+				}
+
+				result, err := next(ctx, call)
+				if err == nil {
+					events = append(events, name+":result:"+result.Response.Payload.Choices.Text[0].Content)
+				}
+				return result, err
+			}
+		}
+	}
+
+	scrub := func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*Result, error) {
+			scrubbed := *call.Request
+			scrubbed.Messages = []SparkMessage{{Role: "user", Content: strings.ReplaceAll(call.Request.Messages[0].Content, "13800000000", "[phone]")}}
+			call.Request = &scrubbed
+			return next(ctx, call)
+		}
+	}
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+		WithDomain("lite"),
+		WithMiddleware(record("outer"), scrub, record("inner")),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	resp, err := client.ChatSimple(context.Background(), "call 13800000000")
+	if err != nil {
+		t.Fatalf("ChatSimple failed: %v", err)
+	}
+	if resp.Payload.Choices.Text[0].Content != "Hello world" {
+		t.Errorf("unexpected content %q", resp.Payload.Choices.Text[0].Content)
+	}
+	if got := sent.Payload.Message.Text[0].Content; got != "call [phone]" {
+		t.Errorf("expected scrubbed message to be sent, got %q", got)
+	}
+
+	want := []string{
+		"outer:request:call 13800000000",
+		"inner:request:call [phone]",
+		"inner:assembled:lite",
+		"outer:assembled:lite",
+		"inner:frame:Hello ",
+		"outer:frame:Hello ",
+		"inner:frame:world",
+		"outer:frame:world",
+		"inner:result:Hello world",
+		"outer:result:Hello world",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected events\n got: %q\nwant: %q", events, want)
+	}
+}
+
+func TestSparkClient_MiddlewareShortCircuitsEmbedding(t *testing.T) {
+	cached := &SparkAPIEmbResponse{}
+	cached.Payload.Feature.Encoding = "utf8"
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs("ws://unused.invalid", "ws://unused.invalid"),
+		WithMiddleware(func(next Handler) Handler {
+			return func(ctx context.Context, call *Call) (*Result, error) {
+				if call.Kind == CallEmbedding && call.Query == "known" {
+					return &Result{Embedding: cached}, nil
+				}
+				return next(ctx, call)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	resp, err := client.Embedding(context.Background(), "known", "query")
+	if err != nil {
+		t.Fatalf("Embedding failed: %v", err)
+	}
+	if resp != cached {
+		t.Error("expected cached embedding from middleware")
+	}
+}
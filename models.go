@@ -1,6 +1,12 @@
 package gosparkclient
 
-import "encoding/json"
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
 
 type ChatCallback func(resp *SparkAPIResponse)
 
@@ -8,6 +14,8 @@ type ChatCallback func(resp *SparkAPIResponse)
 type SparkMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ContentType is "image" for base64 encoded images and "text" or empty otherwise
+	ContentType string `json:"content_type,omitempty"`
 }
 
 // SparkHeader represents common header structure
@@ -51,6 +59,25 @@ type SparkChatRequest struct {
 	System       string          `json:"system,omitempty"`
 	QuestionType string          `json:"question_type,omitempty"`
 	Functions    json.RawMessage `json:"functions,omitempty"`
+	// WebSearch configures the web_search tool, left at the model's default when nil
+	WebSearch *WebSearchOptions `json:"web_search,omitempty"`
+
+	TopP             float64         `json:"top_p,omitempty"`
+	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
+	ChatID           string          `json:"chat_id,omitempty"`
+
+	// Extra is merged into the chat parameters of the outgoing request,
+	// overriding fields of the same name. It allows parameters this library
+	// does not model yet
+	Extra map[string]any `json:"extra,omitempty"`
+}
+
+// ResponseFormat asks the model for a particular output format
+type ResponseFormat struct {
+	Type string `json:"type"`
 }
 
 // SparkAPIRequest represents the full API request structure
@@ -58,16 +85,11 @@ type SparkAPIRequest struct {
 	Header struct {
 		AppID string `json:"app_id"`
 		UID   string `json:"uid"`
+		// PatchID holds the resource IDs of a fine-tuned model
+		PatchID []string `json:"patch_id,omitempty"`
 	} `json:"header"`
 	Parameter struct {
-		Chat struct {
-			Domain       string  `json:"domain"`
-			Temperature  float64 `json:"temperature,omitempty"`
-			MaxTokens    int     `json:"max_tokens,omitempty"`
-			TopK         int     `json:"top_k,omitempty"`
-			Auditing     string  `json:"auditing"`
-			QuestionType string  `json:"question_type,omitempty"`
-		} `json:"chat"`
+		Chat SparkChatParameters `json:"chat"`
 	} `json:"parameter"`
 	Payload struct {
 		Message struct {
@@ -79,6 +101,44 @@ type SparkAPIRequest struct {
 	} `json:"functions,omitempty"`
 }
 
+// SparkChatParameters are the chat parameters of an API request
+type SparkChatParameters struct {
+	Domain           string          `json:"domain"`
+	Temperature      float64         `json:"temperature,omitempty"`
+	MaxTokens        int             `json:"max_tokens,omitempty"`
+	TopK             int             `json:"top_k,omitempty"`
+	TopP             float64         `json:"top_p,omitempty"`
+	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
+	ChatID           string          `json:"chat_id,omitempty"`
+	Auditing         string          `json:"auditing"`
+	QuestionType     string          `json:"question_type,omitempty"`
+	Tools            []SparkTool     `json:"tools,omitempty"`
+
+	// Extra is merged into the encoded parameters
+	Extra map[string]any `json:"-"`
+}
+
+// MarshalJSON encodes the parameters with Extra merged in
+func (p SparkChatParameters) MarshalJSON() ([]byte, error) {
+	type plain SparkChatParameters
+	data, err := json.Marshal(plain(p))
+	if err != nil || len(p.Extra) == 0 {
+		return data, err
+	}
+
+	merged := map[string]any{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for k, v := range p.Extra {
+		merged[k] = v
+	}
+	return json.Marshal(merged)
+}
+
 // SparkAPIResponse represents the API response structure
 type SparkAPIResponse struct {
 	Header  SparkHeader `json:"header"`
@@ -93,26 +153,39 @@ type SparkAPIResponse struct {
 		} `json:"usage"`
 		Plugins *SparkPlugins `json:"plugins,omitempty"`
 	} `json:"payload"`
+
+	// Model is the endpoint and domain that produced this response
+	Model ModelTarget `json:"-"`
+
+	// Citations collects the web search results of all frames. It is set on
+	// the response returned by Chat
+	Citations []Citation `json:"-"`
 }
 
 // SparkPlugins represents plugin-related information
 type SparkPlugins struct {
-	Text []struct {
-		Name        string `json:"name"`
-		Content     string `json:"content"`
-		ContentType string `json:"content_type"`
-		ContentMeta any    `json:"content_meta"`
-		Role        string `json:"role"`
-		Status      string `json:"status"`
-		Invoked     struct {
-			Namespace  string `json:"namespace"`
-			PluginID   string `json:"plugin_id"`
-			PluginVer  string `json:"plugin_ver"`
-			StatusCode int    `json:"status_code"`
-			StatusMsg  string `json:"status_msg"`
-			Type       string `json:"type"`
-		} `json:"invoked"`
-	} `json:"text"`
+	Text []PluginResult `json:"text"`
+}
+
+// PluginResult is the output of a plugin the model invoked while answering
+type PluginResult struct {
+	Name        string           `json:"name"`
+	Content     string           `json:"content"`
+	ContentType string           `json:"content_type"`
+	ContentMeta json.RawMessage  `json:"content_meta,omitempty"`
+	Role        string           `json:"role"`
+	Status      string           `json:"status"`
+	Invoked     PluginInvocation `json:"invoked"`
+}
+
+// PluginInvocation describes how a plugin was invoked
+type PluginInvocation struct {
+	Namespace  string `json:"namespace"`
+	PluginID   string `json:"plugin_id"`
+	PluginVer  string `json:"plugin_ver"`
+	StatusCode int    `json:"status_code"`
+	StatusMsg  string `json:"status_msg"`
+	Type       string `json:"type"`
 }
 
 // SparkFeature represents feature-related information
@@ -120,6 +193,7 @@ type SparkFeature struct {
 	Encoding string `json:"encoding"`
 	Compress string `json:"compress"`
 	Format   string `json:"format"`
+	Text     string `json:"text"`
 }
 
 // SparkAPIEmbRequest represents an embedding request
@@ -157,3 +231,19 @@ type SparkAPIEmbResponse struct {
 		Feature SparkFeature `json:"feature"`
 	} `json:"payload"`
 }
+
+// Vector decodes the embedding, which Spark returns as base64 encoded little-endian float32 values
+func (r *SparkAPIEmbResponse) Vector() ([]float32, error) {
+	data, err := base64.StdEncoding.DecodeString(r.Payload.Feature.Text)
+	if err != nil {
+		return nil, fmt.Errorf("decode embedding: %w", err)
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("decode embedding: %d bytes is not a whole number of float32 values", len(data))
+	}
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return vector, nil
+}
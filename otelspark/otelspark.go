@@ -0,0 +1,183 @@
+// Package otelspark instruments gosparkclient with OpenTelemetry tracing.
+// It lives in its own package so that the core client does not depend on OpenTelemetry
+package otelspark
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fruitbars/gosparkclient"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/fruitbars/gosparkclient/otelspark"
+
+// Attribute keys following the OpenTelemetry GenAI semantic conventions,
+// plus Spark specific keys under the spark namespace
+const (
+	AttrSystem           = attribute.Key("gen_ai.system")
+	AttrOperationName    = attribute.Key("gen_ai.operation.name")
+	AttrRequestModel     = attribute.Key("gen_ai.request.model")
+	AttrRequestMaxTokens = attribute.Key("gen_ai.request.max_tokens")
+	AttrRequestTemp      = attribute.Key("gen_ai.request.temperature")
+	AttrRequestTopK      = attribute.Key("gen_ai.request.top_k")
+	AttrResponseModel    = attribute.Key("gen_ai.response.model")
+	AttrResponseID       = attribute.Key("gen_ai.response.id")
+	AttrInputTokens      = attribute.Key("gen_ai.usage.input_tokens")
+	AttrOutputTokens     = attribute.Key("gen_ai.usage.output_tokens")
+	AttrErrorType        = attribute.Key("error.type")
+	AttrSparkSID         = attribute.Key("spark.sid")
+	AttrSparkErrorCode   = attribute.Key("spark.error_code")
+	AttrSparkURL         = attribute.Key("spark.url")
+	AttrSparkDialLatency = attribute.Key("spark.dial.duration_ms")
+)
+
+// SystemName is the gen_ai.system value reported for Spark
+const SystemName = "iflytek_spark"
+
+// Event names added to call spans
+const (
+	EventDial       = "spark.dial"
+	EventFirstToken = "spark.first_token"
+	EventCompletion = "spark.completion"
+)
+
+type config struct {
+	provider trace.TracerProvider
+}
+
+// Option configures the tracing middleware
+type Option func(*config)
+
+// WithTracerProvider sets the tracer provider, defaulting to the global provider
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// Middleware returns gosparkclient middleware that records a client span for
+// every Chat, ChatWithCallback and Embedding call
+func Middleware(opts ...Option) gosparkclient.Middleware {
+	cfg := &config{provider: otel.GetTracerProvider()}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	tracer := cfg.provider.Tracer(instrumentationName)
+
+	return func(next gosparkclient.Handler) gosparkclient.Handler {
+		return func(ctx context.Context, call *gosparkclient.Call) (*gosparkclient.Result, error) {
+			operation := "chat"
+			attrs := []attribute.KeyValue{AttrSystem.String(SystemName)}
+			if call.Kind == gosparkclient.CallEmbedding {
+				operation = "embeddings"
+				attrs = append(attrs, AttrRequestModel.String(call.EmbeddingDomain))
+			} else if req := call.Request; req != nil {
+				if req.MaxTokens != 0 {
+					attrs = append(attrs, AttrRequestMaxTokens.Int(req.MaxTokens))
+				}
+				if req.Temperature != 0 {
+					attrs = append(attrs, AttrRequestTemp.Float64(req.Temperature))
+				}
+				if req.TopK != 0 {
+					attrs = append(attrs, AttrRequestTopK.Int(req.TopK))
+				}
+			}
+			attrs = append(attrs, AttrOperationName.String(operation))
+
+			ctx, span := tracer.Start(ctx, spanName(operation, call.EmbeddingDomain),
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attrs...),
+			)
+			defer span.End()
+
+			var dialStart time.Time
+			ctx = gosparkclient.WithClientTrace(ctx, &gosparkclient.ClientTrace{
+				DialStart: func(hostURL string) {
+					dialStart = time.Now()
+				},
+				DialDone: func(hostURL string, err error) {
+					eventAttrs := []attribute.KeyValue{
+						AttrSparkURL.String(hostURL),
+						AttrSparkDialLatency.Int64(time.Since(dialStart).Milliseconds()),
+					}
+					if err != nil {
+						eventAttrs = append(eventAttrs, attribute.String("exception.message", err.Error()))
+					}
+					span.AddEvent(EventDial, trace.WithAttributes(eventAttrs...))
+				},
+				FirstFrame: func() {
+					span.AddEvent(EventFirstToken)
+				},
+			})
+
+			// The primary model is only known once the request is assembled
+			requestModelSet := false
+			onRequest := call.OnRequest
+			call.OnRequest = func(apiReq *gosparkclient.SparkAPIRequest) {
+				if !requestModelSet {
+					requestModelSet = true
+					model := apiReq.Parameter.Chat.Domain
+					span.SetName(spanName(operation, model))
+					span.SetAttributes(AttrRequestModel.String(model))
+				}
+				if onRequest != nil {
+					onRequest(apiReq)
+				}
+			}
+
+			result, err := next(ctx, call)
+			if err != nil {
+				recordError(span, err)
+				return result, err
+			}
+
+			if result != nil && result.Response != nil {
+				resp := result.Response
+				usage := resp.Payload.Usage.Text
+				span.SetAttributes(
+					AttrResponseModel.String(resp.Model.Domain),
+					AttrResponseID.String(resp.Header.SID),
+					AttrSparkSID.String(resp.Header.SID),
+					AttrInputTokens.Int(usage.PromptTokens),
+					AttrOutputTokens.Int(usage.CompletionTokens),
+				)
+			} else if result != nil && result.Embedding != nil {
+				span.SetAttributes(
+					AttrResponseID.String(result.Embedding.Header.SID),
+					AttrSparkSID.String(result.Embedding.Header.SID),
+				)
+			}
+			span.AddEvent(EventCompletion)
+			return result, nil
+		}
+	}
+}
+
+// spanName follows the GenAI convention of "{operation} {model}"
+func spanName(operation, model string) string {
+	if model == "" {
+		return operation
+	}
+	return operation + " " + model
+}
+
+// recordError marks span as failed with the Spark error type and code
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	var sparkErr *gosparkclient.SparkError
+	if errors.As(err, &sparkErr) {
+		span.SetAttributes(AttrErrorType.String(string(sparkErr.Type)))
+		if sparkErr.Code != 0 {
+			span.SetAttributes(AttrSparkErrorCode.Int(sparkErr.Code))
+		}
+		return
+	}
+	span.SetAttributes(AttrErrorType.String("_OTHER"))
+}
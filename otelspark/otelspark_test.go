@@ -0,0 +1,134 @@
+package otelspark
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fruitbars/gosparkclient"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newMockServer starts a WebSocket server that reads one request and replies with frames
+func newMockServer(t *testing.T, frames ...string) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		for _, frame := range frames {
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+	}))
+}
+
+func newTracedClient(t *testing.T, server *httptest.Server) (*gosparkclient.SparkClient, *tracetest.SpanRecorder) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	client, err := gosparkclient.NewSparkClient(
+		gosparkclient.WithCredentials("app", "key", "secret"),
+		gosparkclient.WithURLs(url, url),
+		gosparkclient.WithDomain("4.0Ultra"),
+		gosparkclient.WithMiddleware(Middleware(WithTracerProvider(provider))),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return client, recorder
+}
+
+func attrMap(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestMiddleware_ChatSpan(t *testing.T) {
+	server := newMockServer(t,
+		`{"header":{"code":0,"sid":"sid-9"},"payload":{"choices":{"status":1,"text":[{"content":"a"}]}}}`,
+		`{"header":{"code":0,"sid":"sid-9"},"payload":{"choices":{"status":2,"text":[{"content":"b"}]},"usage":{"text":{"prompt_tokens":11,"completion_tokens":4,"total_tokens":15}}}}`,
+	)
+	defer server.Close()
+
+	client, recorder := newTracedClient(t, server)
+	err := client.ChatWithCallback(context.Background(), &gosparkclient.SparkChatRequest{
+		Messages:  []gosparkclient.SparkMessage{{Role: "user", Content: "hi"}},
+		MaxTokens: 256,
+	}, nil)
+	if err != nil {
+		t.Fatalf("ChatWithCallback failed: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "chat 4.0Ultra" {
+		t.Errorf("unexpected span name %q", span.Name())
+	}
+
+	attrs := attrMap(span)
+	checks := map[attribute.Key]attribute.Value{
+		AttrOperationName:    attribute.StringValue("chat"),
+		AttrRequestModel:     attribute.StringValue("4.0Ultra"),
+		AttrResponseModel:    attribute.StringValue("4.0Ultra"),
+		AttrRequestMaxTokens: attribute.IntValue(256),
+		AttrSparkSID:         attribute.StringValue("sid-9"),
+		AttrInputTokens:      attribute.IntValue(11),
+		AttrOutputTokens:     attribute.IntValue(4),
+	}
+	for key, want := range checks {
+		if got, ok := attrs[key]; !ok || got != want {
+			t.Errorf("%s = %v, want %v", key, got.Emit(), want.Emit())
+		}
+	}
+
+	var events []string
+	for _, event := range span.Events() {
+		events = append(events, event.Name)
+	}
+	if strings.Join(events, ",") != EventDial+","+EventFirstToken+","+EventCompletion {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestMiddleware_ErrorSpan(t *testing.T) {
+	server := newMockServer(t, `{"header":{"code":10110,"message":"busy","sid":"sid-err"}}`)
+	defer server.Close()
+
+	client, recorder := newTracedClient(t, server)
+	if _, err := client.ChatSimple(context.Background(), "hi"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	span := recorder.Ended()[0]
+	if span.Status().Code != codes.Error {
+		t.Errorf("expected error status, got %v", span.Status())
+	}
+	attrs := attrMap(span)
+	if attrs[AttrSparkErrorCode].AsInt64() != 10110 {
+		t.Errorf("expected error code 10110, got %v", attrs[AttrSparkErrorCode].Emit())
+	}
+	if attrs[AttrErrorType].AsString() != string(gosparkclient.ErrResponse) {
+		t.Errorf("unexpected error type %v", attrs[AttrErrorType].Emit())
+	}
+}
@@ -0,0 +1,139 @@
+package gosparkclient
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Response formats
+const (
+	ResponseFormatText = "text"
+	ResponseFormatJSON = "json_object"
+)
+
+// Chat parameters that not every model version accepts
+const (
+	ParamTopP             = "top_p"
+	ParamPresencePenalty  = "presence_penalty"
+	ParamFrequencyPenalty = "frequency_penalty"
+	ParamStop             = "stop"
+	ParamResponseFormat   = "response_format"
+)
+
+// DefaultModelParameters lists, per domain, which of the Param* parameters
+// the model accepts. Domains that are not listed accept all of them
+var DefaultModelParameters = map[string][]string{
+	"lite":        {},
+	"generalv3":   {},
+	"pro-128k":    {},
+	"generalv3.5": {},
+	"max-32k":     {ParamTopP, ParamPresencePenalty, ParamFrequencyPenalty, ParamStop, ParamResponseFormat},
+	"4.0Ultra":    {ParamTopP, ParamPresencePenalty, ParamFrequencyPenalty, ParamStop, ParamResponseFormat},
+}
+
+// reservedExtraParams are set from the client's configuration and may not be
+// replaced through Extra, since caching, metrics and budgets rely on them
+var reservedExtraParams = []string{"domain", "auditing"}
+
+// validateParams checks the parameters of req against their ranges and the
+// parameters supported by domain
+func (c *SparkClient) validateParams(req *SparkChatRequest, domain string) error {
+	if req == nil {
+		return nil
+	}
+
+	var used []string
+	if req.TopP != 0 {
+		if req.TopP < 0 || req.TopP > 1 {
+			return newRequestError(fmt.Sprintf("top_p must be in (0, 1], got %v", req.TopP), nil)
+		}
+		used = append(used, ParamTopP)
+	}
+	if req.PresencePenalty != 0 {
+		if req.PresencePenalty < -2 || req.PresencePenalty > 2 {
+			return newRequestError(fmt.Sprintf("presence_penalty must be in [-2, 2], got %v", req.PresencePenalty), nil)
+		}
+		used = append(used, ParamPresencePenalty)
+	}
+	if req.FrequencyPenalty != 0 {
+		if req.FrequencyPenalty < -2 || req.FrequencyPenalty > 2 {
+			return newRequestError(fmt.Sprintf("frequency_penalty must be in [-2, 2], got %v", req.FrequencyPenalty), nil)
+		}
+		used = append(used, ParamFrequencyPenalty)
+	}
+	if len(req.Stop) > 0 {
+		used = append(used, ParamStop)
+	}
+	if req.ResponseFormat != nil {
+		if t := req.ResponseFormat.Type; t != ResponseFormatText && t != ResponseFormatJSON {
+			return newRequestError(fmt.Sprintf("unknown response_format type %q", t), nil)
+		}
+		used = append(used, ParamResponseFormat)
+	}
+	for _, key := range reservedExtraParams {
+		if _, ok := req.Extra[key]; ok {
+			return newRequestError(fmt.Sprintf("Extra must not set %s; use the client or request options instead", key), nil)
+		}
+	}
+
+	supported, ok := c.modelParameters(domain)
+	if !ok {
+		return nil
+	}
+	var unsupported []string
+	for _, param := range used {
+		if !containsString(supported, param) {
+			unsupported = append(unsupported, param)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return newRequestError(fmt.Sprintf("%s does not support %s", domain, strings.Join(unsupported, ", ")), nil)
+	}
+	return nil
+}
+
+// withoutUnsupportedParams returns a copy of req without the parameters domain
+// does not accept
+func (c *SparkClient) withoutUnsupportedParams(req *SparkChatRequest, domain string) *SparkChatRequest {
+	supported, ok := c.modelParameters(domain)
+	if req == nil || !ok {
+		return req
+	}
+	r := *req
+	if !containsString(supported, ParamTopP) {
+		r.TopP = 0
+	}
+	if !containsString(supported, ParamPresencePenalty) {
+		r.PresencePenalty = 0
+	}
+	if !containsString(supported, ParamFrequencyPenalty) {
+		r.FrequencyPenalty = 0
+	}
+	if !containsString(supported, ParamStop) {
+		r.Stop = nil
+	}
+	if !containsString(supported, ParamResponseFormat) {
+		r.ResponseFormat = nil
+	}
+	return &r
+}
+
+// modelParameters returns the parameters domain accepts
+func (c *SparkClient) modelParameters(domain string) ([]string, bool) {
+	if params, ok := c.config.ModelParameters[domain]; ok {
+		return params, true
+	}
+	params, ok := DefaultModelParameters[domain]
+	return params, ok
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
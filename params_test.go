@@ -0,0 +1,116 @@
+package gosparkclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSparkClient_ExtendedParams(t *testing.T) {
+	var sent map[string]any
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if err := conn.ReadJSON(&sent); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"{}"}]}}}`))
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+		WithDomain("4.0Ultra"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	_, err = client.Chat(context.Background(), &SparkChatRequest{
+		Messages:         []SparkMessage{{Role: "user", Content: "hi"}},
+		TopP:             0.8,
+		PresencePenalty:  1.5,
+		FrequencyPenalty: -0.5,
+		ResponseFormat:   &ResponseFormat{Type: ResponseFormatJSON},
+		ChatID:           "session-1",
+		Extra:            map[string]any{"new_param": "x", "top_k": 6},
+	})
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	chat := sent["parameter"].(map[string]any)["chat"].(map[string]any)
+	want := map[string]any{
+		"domain":            "4.0Ultra",
+		"auditing":          "default",
+		"top_p":             0.8,
+		"presence_penalty":  1.5,
+		"frequency_penalty": -0.5,
+		"response_format":   map[string]any{"type": "json_object"},
+		"chat_id":           "session-1",
+		"new_param":         "x",
+		"top_k":             float64(6),
+	}
+	if !reflect.DeepEqual(chat, want) {
+		t.Errorf("chat parameters = %v, want %v", chat, want)
+	}
+}
+
+func TestSparkClient_ValidateParams(t *testing.T) {
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs("ws://unused", ""),
+		WithModelParameters("custom", ParamStop),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		domain  string
+		req     SparkChatRequest
+		wantErr string
+	}{
+		{"top_p out of range", "4.0Ultra", SparkChatRequest{TopP: 1.5}, "top_p"},
+		{"penalty out of range", "4.0Ultra", SparkChatRequest{PresencePenalty: 3}, "presence_penalty"},
+		{"unknown format", "4.0Ultra", SparkChatRequest{ResponseFormat: &ResponseFormat{Type: "xml"}}, "response_format"},
+		{"unsupported by lite", "lite", SparkChatRequest{TopP: 0.5, ResponseFormat: &ResponseFormat{Type: ResponseFormatJSON}}, "lite does not support response_format, top_p"},
+		{"custom override", "custom", SparkChatRequest{TopP: 0.5}, "custom does not support top_p"},
+		{"custom supported", "custom", SparkChatRequest{Stop: []string{"\n"}}, ""},
+		{"unknown domain", "x1", SparkChatRequest{TopP: 0.5, Stop: []string{"\n"}}, ""},
+		{"base parameters", "lite", SparkChatRequest{Temperature: 0.5, ChatID: "c"}, ""},
+		{"stop on 4.0Ultra", "4.0Ultra", SparkChatRequest{Stop: []string{"\n"}}, ""},
+		{"extra domain", "x1", SparkChatRequest{Extra: map[string]any{"domain": "4.0Ultra"}}, "Extra must not set domain"},
+		{"extra auditing", "x1", SparkChatRequest{Extra: map[string]any{"auditing": "off"}}, "Extra must not set auditing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.validateParams(&tt.req, tt.domain)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var sparkErr *SparkError
+			if !errors.As(err, &sparkErr) || sparkErr.Type != ErrRequest || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected request error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSparkChatParameters_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(SparkChatParameters{Domain: "lite", Auditing: "default"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `{"domain":"lite","auditing":"default"}` {
+		t.Errorf("unexpected encoding %s", data)
+	}
+}
@@ -0,0 +1,106 @@
+package gosparkclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// PluginDecoder decodes the content of a plugin result into a typed value
+type PluginDecoder func(result PluginResult) (any, error)
+
+var (
+	pluginDecodersMu sync.RWMutex
+	pluginDecoders   = map[string]PluginDecoder{
+		searchPluginName: func(result PluginResult) (any, error) {
+			return decodeSearchResults(result)
+		},
+	}
+)
+
+// RegisterPluginDecoder sets the decoder used by PluginResult.Decode for the
+// plugin called name, replacing any decoder registered before. The web search
+// plugin decodes to []Citation out of the box. Spark does not document the
+// content of other plugins such as code execution, so none is built in for
+// them; register one with JSONPluginDecoder for the format you observe
+func RegisterPluginDecoder(name string, decoder PluginDecoder) {
+	pluginDecodersMu.Lock()
+	defer pluginDecodersMu.Unlock()
+	pluginDecoders[name] = decoder
+}
+
+// JSONPluginDecoder returns a decoder that unmarshals the content of a plugin result into T
+func JSONPluginDecoder[T any]() PluginDecoder {
+	return func(result PluginResult) (any, error) {
+		var v T
+		if err := json.Unmarshal([]byte(result.Content), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+// Decode decodes the result's content with the decoder registered for its
+// plugin. Results of plugins without a decoder decode to their raw content
+func (r PluginResult) Decode() (any, error) {
+	pluginDecodersMu.RLock()
+	decoder, ok := pluginDecoders[r.Name]
+	pluginDecodersMu.RUnlock()
+
+	if !ok {
+		return r.Content, nil
+	}
+	v, err := decoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s result: %w", r.Name, err)
+	}
+	return v, nil
+}
+
+// pluginKey identifies a plugin invocation across frames
+func (r PluginResult) pluginKey() string {
+	return r.Name + "\x00" + r.Invoked.PluginID
+}
+
+// pluginAggregator collects the plugin results streamed across frames, keeping
+// the latest result of every invocation in the order they first appeared
+type pluginAggregator struct {
+	order   []string
+	results map[string]PluginResult
+}
+
+// add records the plugin results of a frame and returns those whose status changed
+func (a *pluginAggregator) add(plugins *SparkPlugins) []PluginResult {
+	if plugins == nil {
+		return nil
+	}
+	if a.results == nil {
+		a.results = map[string]PluginResult{}
+	}
+
+	var changed []PluginResult
+	for _, r := range plugins.Text {
+		key := r.pluginKey()
+		prev, seen := a.results[key]
+		if !seen {
+			a.order = append(a.order, key)
+		}
+		if !seen || prev.Status != r.Status {
+			changed = append(changed, r)
+		}
+		a.results[key] = r
+	}
+	return changed
+}
+
+// plugins returns the aggregated results, or nil when no plugin ran
+func (a *pluginAggregator) plugins() *SparkPlugins {
+	if len(a.order) == 0 {
+		return nil
+	}
+	out := &SparkPlugins{Text: make([]PluginResult, 0, len(a.order))}
+	for _, key := range a.order {
+		out.Text = append(out.Text, a.results[key])
+	}
+	return out
+}
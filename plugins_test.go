@@ -0,0 +1,82 @@
+package gosparkclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPluginResult_Decode(t *testing.T) {
+	search := PluginResult{Name: "ifly_search", Content: `[{"index":1,"url":"https://a.example","title":"A"}]`}
+	v, err := search.Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if want := []Citation{{Index: 1, Title: "A", URL: "https://a.example"}}; !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode = %#v, want %#v", v, want)
+	}
+
+	type execution struct {
+		Code   string `json:"code"`
+		Output string `json:"output"`
+	}
+	RegisterPluginDecoder("test_code", JSONPluginDecoder[execution]())
+	v, err = PluginResult{Name: "test_code", Content: `{"code":"print(1)","output":"1"}`}.Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if want := (execution{Code: "print(1)", Output: "1"}); v != want {
+		t.Errorf("Decode = %#v, want %#v", v, want)
+	}
+	if _, err := (PluginResult{Name: "test_code", Content: "oops"}).Decode(); err == nil {
+		t.Error("expected decode error")
+	}
+
+	if v, err := (PluginResult{Name: "unknown", Content: "raw"}).Decode(); err != nil || v != "raw" {
+		t.Errorf("Decode of unknown plugin = %v, %v", v, err)
+	}
+}
+
+func TestSparkClient_PluginStatus(t *testing.T) {
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		frames := []string{
+			`{"header":{"code":0},"payload":{"choices":{"status":0,"text":[{"content":""}]},"plugins":{"text":[{"name":"calc","status":"running","invoked":{"plugin_id":"p1"}}]}}}`,
+			`{"header":{"code":0},"payload":{"choices":{"status":1,"text":[{"content":""}]},"plugins":{"text":[{"name":"calc","status":"running","invoked":{"plugin_id":"p1"}}]}}}`,
+			`{"header":{"code":0},"payload":{"choices":{"status":1,"text":[{"content":"结果是 "}]},"plugins":{"text":[{"name":"calc","status":"finished","content":"42","invoked":{"plugin_id":"p1"}}]}}}`,
+			`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"42"}]}}}`,
+		}
+		for _, frame := range frames {
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(WithCredentials("app", "key", "secret"), WithURLs(wsURL(server), ""))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var statuses []string
+	ctx := WithClientTrace(context.Background(), &ClientTrace{
+		PluginStatus: func(result PluginResult) {
+			statuses = append(statuses, result.Name+":"+result.Status)
+		},
+	})
+	resp, err := client.ChatSimple(ctx, "6 乘 7")
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	if want := []string{"calc:running", "calc:finished"}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+	plugins := resp.Payload.Plugins
+	if plugins == nil || len(plugins.Text) != 1 || plugins.Text[0].Status != "finished" || plugins.Text[0].Content != "42" {
+		t.Errorf("unexpected aggregated plugins %+v", plugins)
+	}
+}
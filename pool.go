@@ -0,0 +1,188 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultEjectDuration = time.Minute
+
+// PoolStrategy selects how a CredentialPool spreads requests over its members
+type PoolStrategy int
+
+const (
+	// RoundRobin cycles through members in proportion to their weights
+	RoundRobin PoolStrategy = iota
+	// LeastInFlight picks the member with the fewest in-flight requests per unit of weight
+	LeastInFlight
+)
+
+// PoolMember is a single credential set in a CredentialPool
+type PoolMember struct {
+	Credentials Credentials
+	// Weight is the member's relative share of traffic, defaulting to 1
+	Weight int
+}
+
+// CredentialStats reports the usage of a single pool member
+type CredentialStats struct {
+	AppID            string
+	APIKey           string
+	Requests         int64
+	Failures         int64
+	InFlight         int
+	PromptTokens     int64
+	CompletionTokens int64
+	TotalTokens      int64
+	EjectedUntil     time.Time
+}
+
+// CredentialPool is a CredentialsProvider that balances requests across several
+// credential sets and temporarily ejects sets that fail with auth or quota errors
+type CredentialPool struct {
+	strategy      PoolStrategy
+	ejectDuration time.Duration
+	now           func() time.Time
+
+	mu      sync.Mutex
+	members []*poolMember
+}
+
+type poolMember struct {
+	creds   Credentials
+	weight  int
+	current int // smooth weighted round-robin state
+	stats   CredentialStats
+}
+
+// PoolOption configures a CredentialPool
+type PoolOption func(*CredentialPool)
+
+// WithPoolStrategy sets the balancing strategy, RoundRobin by default
+func WithPoolStrategy(strategy PoolStrategy) PoolOption {
+	return func(p *CredentialPool) {
+		p.strategy = strategy
+	}
+}
+
+// WithEjectDuration sets how long a failing credential set is taken out of rotation
+func WithEjectDuration(d time.Duration) PoolOption {
+	return func(p *CredentialPool) {
+		p.ejectDuration = d
+	}
+}
+
+// NewCredentialPool creates a pool from the given members
+func NewCredentialPool(members []PoolMember, opts ...PoolOption) (*CredentialPool, error) {
+	if len(members) == 0 {
+		return nil, newConfigError("credential pool is empty", nil)
+	}
+
+	p := &CredentialPool{
+		strategy:      RoundRobin,
+		ejectDuration: defaultEjectDuration,
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	for _, m := range members {
+		if m.Credentials.AppID == "" || m.Credentials.APIKey == "" {
+			return nil, newConfigError("credential pool member requires AppID and ApiKey", nil)
+		}
+		weight := m.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		p.members = append(p.members, &poolMember{
+			creds:  m.Credentials,
+			weight: weight,
+			stats:  CredentialStats{AppID: m.Credentials.AppID, APIKey: m.Credentials.APIKey},
+		})
+	}
+	return p, nil
+}
+
+// Retrieve picks the next available member according to the pool strategy
+func (p *CredentialPool) Retrieve(ctx context.Context) (Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	var available []*poolMember
+	for _, m := range p.members {
+		if !now.Before(m.stats.EjectedUntil) {
+			available = append(available, m)
+		}
+	}
+	if len(available) == 0 {
+		return Credentials{}, errors.New("all pooled credentials are ejected")
+	}
+
+	var picked *poolMember
+	switch p.strategy {
+	case LeastInFlight:
+		for _, m := range available {
+			// Compare InFlight/weight without floating point
+			if picked == nil || m.stats.InFlight*picked.weight < picked.stats.InFlight*m.weight {
+				picked = m
+			}
+		}
+	default:
+		total := 0
+		for _, m := range available {
+			m.current += m.weight
+			total += m.weight
+			if picked == nil || m.current > picked.current {
+				picked = m
+			}
+		}
+		picked.current -= total
+	}
+
+	picked.stats.InFlight++
+	picked.stats.Requests++
+	return picked.creds, nil
+}
+
+// ReportResult records the outcome of a request and ejects the member on credential errors
+func (p *CredentialPool) ReportResult(creds Credentials, usage *SparkUsage, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, m := range p.members {
+		if m.creds.AppID != creds.AppID || m.creds.APIKey != creds.APIKey {
+			continue
+		}
+		if m.stats.InFlight > 0 {
+			m.stats.InFlight--
+		}
+		if usage != nil {
+			m.stats.PromptTokens += int64(usage.PromptTokens)
+			m.stats.CompletionTokens += int64(usage.CompletionTokens)
+			m.stats.TotalTokens += int64(usage.TotalTokens)
+		}
+		if err != nil {
+			m.stats.Failures++
+			if isCredentialError(err) {
+				m.stats.EjectedUntil = p.now().Add(p.ejectDuration)
+			}
+		}
+		return
+	}
+}
+
+// Stats returns a snapshot of per-credential usage in member order
+func (p *CredentialPool) Stats() []CredentialStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]CredentialStats, 0, len(p.members))
+	for _, m := range p.members {
+		stats = append(stats, m.stats)
+	}
+	return stats
+}
@@ -0,0 +1,118 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCredentialPool_WeightedRoundRobin(t *testing.T) {
+	pool, err := NewCredentialPool([]PoolMember{
+		{Credentials: Credentials{AppID: "a", APIKey: "ka"}, Weight: 2},
+		{Credentials: Credentials{AppID: "b", APIKey: "kb"}},
+	})
+	if err != nil {
+		t.Fatalf("NewCredentialPool failed: %v", err)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		creds, err := pool.Retrieve(context.Background())
+		if err != nil {
+			t.Fatalf("Retrieve failed: %v", err)
+		}
+		counts[creds.AppID]++
+		pool.ReportResult(creds, nil, nil)
+	}
+	if counts["a"] != 4 || counts["b"] != 2 {
+		t.Errorf("expected 4/2 split, got %v", counts)
+	}
+}
+
+func TestCredentialPool_LeastInFlight(t *testing.T) {
+	pool, _ := NewCredentialPool([]PoolMember{
+		{Credentials: Credentials{AppID: "a", APIKey: "ka"}},
+		{Credentials: Credentials{AppID: "b", APIKey: "kb"}},
+	}, WithPoolStrategy(LeastInFlight))
+
+	first, _ := pool.Retrieve(context.Background())
+	second, _ := pool.Retrieve(context.Background())
+	if first.AppID == second.AppID {
+		t.Fatalf("expected different members while first is in flight, got %s twice", first.AppID)
+	}
+
+	pool.ReportResult(second, nil, nil)
+	third, _ := pool.Retrieve(context.Background())
+	if third.AppID != second.AppID {
+		t.Errorf("expected idle member %s, got %s", second.AppID, third.AppID)
+	}
+}
+
+func TestCredentialPool_EjectsOnQuotaError(t *testing.T) {
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		if req.Header.AppID == "exhausted" {
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":11201,"message":"daily limit"}}`))
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]},"usage":{"text":{"prompt_tokens":3,"completion_tokens":4,"total_tokens":7}}}}`))
+	})
+	defer server.Close()
+
+	pool, _ := NewCredentialPool([]PoolMember{
+		{Credentials: Credentials{AppID: "exhausted", APIKey: "k1", APISecret: "s1"}},
+		{Credentials: Credentials{AppID: "healthy", APIKey: "k2", APISecret: "s2"}},
+	}, WithEjectDuration(time.Hour))
+
+	client, err := NewSparkClient(
+		WithCredentialsProvider(pool),
+		WithURLs(wsURL(server), ""),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if _, err := client.ChatSimple(context.Background(), "hi"); err == nil {
+		t.Fatal("expected quota error from first member")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := client.ChatSimple(context.Background(), "hi"); err != nil {
+			t.Fatalf("expected healthy member to serve request, got %v", err)
+		}
+	}
+
+	stats := pool.Stats()
+	if stats[0].EjectedUntil.IsZero() || stats[0].Failures != 1 || stats[0].InFlight != 0 {
+		t.Errorf("unexpected stats for ejected member: %+v", stats[0])
+	}
+	if stats[1].Requests != 3 || stats[1].TotalTokens != 21 {
+		t.Errorf("unexpected stats for healthy member: %+v", stats[1])
+	}
+}
+
+func TestCredentialPool_InvalidURLIsNotACredentialFailure(t *testing.T) {
+	pool, _ := NewCredentialPool([]PoolMember{
+		{Credentials: Credentials{AppID: "a", APIKey: "ka", APISecret: "sa"}},
+	}, WithEjectDuration(time.Hour))
+	client, err := NewSparkClient(WithCredentialsProvider(pool), WithURLs("ws://unused", ""))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := client.ChatSimple(context.Background(), "hi", WithRequestHostURL("ws://bad host/%zz"))
+		var sparkErr *SparkError
+		if !errors.As(err, &sparkErr) || sparkErr.Type != ErrRequest {
+			t.Fatalf("expected request error, got %v", err)
+		}
+	}
+	if stats := pool.Stats()[0]; !stats.EjectedUntil.IsZero() || stats.Failures != 0 || stats.InFlight != 0 || stats.Requests != 0 {
+		t.Errorf("invalid URL was charged to the credential: %+v", stats)
+	}
+}
@@ -0,0 +1,404 @@
+package gosparkclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Variable types accepted by PromptVariable.Type
+const (
+	PromptString = "string"
+	PromptInt    = "int"
+	PromptNumber = "number"
+	PromptBool   = "bool"
+	PromptList   = "list"
+)
+
+// PromptVariable declares a variable a prompt template expects
+type PromptVariable struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"` // one of the Prompt* types, string by default
+	Required bool   `json:"required,omitempty"`
+	Default  any    `json:"default,omitempty"`
+}
+
+// PromptExample is a few-shot exchange inserted before the template's messages
+type PromptExample struct {
+	User      string `json:"user"`
+	Assistant string `json:"assistant"`
+}
+
+// PromptTemplate renders variables into a SparkChatRequest. System, example and
+// message contents are text/template templates over the variables
+type PromptTemplate struct {
+	Name        string           `json:"name"`
+	Version     string           `json:"version"`
+	Variables   []PromptVariable `json:"variables,omitempty"`
+	System      string           `json:"system,omitempty"`
+	Examples    []PromptExample  `json:"examples,omitempty"`
+	Messages    []SparkMessage   `json:"messages"`
+	Temperature float64          `json:"temperature,omitempty"`
+	TopK        int              `json:"top_k,omitempty"`
+	MaxTokens   int              `json:"max_tokens,omitempty"`
+
+	compiled   *template.Template
+	compileErr error
+	once       sync.Once
+}
+
+// promptFuncs are the functions available inside prompt templates
+var promptFuncs = template.FuncMap{
+	"join": func(items any, sep string) string {
+		v := reflect.ValueOf(items)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return fmt.Sprint(items)
+		}
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(parts, sep)
+	},
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// ParsePromptTemplate parses a JSON prompt template and compiles its contents
+func ParsePromptTemplate(data []byte) (*PromptTemplate, error) {
+	var t PromptTemplate
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("parse prompt template: %w", err)
+	}
+	if err := t.Compile(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Compile checks the template's declarations and compiles its contents. It is
+// called by ParsePromptTemplate and must be called on templates built in code
+// before Render
+func (t *PromptTemplate) Compile() error {
+	seen := map[string]bool{}
+	for _, v := range t.Variables {
+		if v.Name == "" {
+			return fmt.Errorf("prompt %s: variable without a name", t.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("prompt %s: variable %q declared twice", t.Name, v.Name)
+		}
+		seen[v.Name] = true
+		switch v.Type {
+		case "", PromptString, PromptInt, PromptNumber, PromptBool, PromptList:
+		default:
+			return fmt.Errorf("prompt %s: variable %q has unknown type %q", t.Name, v.Name, v.Type)
+		}
+	}
+
+	root := template.New(t.Name).Funcs(promptFuncs).Option("missingkey=error")
+	parse := func(name, text string) error {
+		if _, err := root.New(name).Parse(text); err != nil {
+			return fmt.Errorf("prompt %s: %w", t.Name, err)
+		}
+		return nil
+	}
+	if err := parse("system", t.System); err != nil {
+		return err
+	}
+	for i, ex := range t.Examples {
+		if err := parse(fmt.Sprintf("example.%d.user", i), ex.User); err != nil {
+			return err
+		}
+		if err := parse(fmt.Sprintf("example.%d.assistant", i), ex.Assistant); err != nil {
+			return err
+		}
+	}
+	for i, msg := range t.Messages {
+		if err := parse(fmt.Sprintf("message.%d", i), msg.Content); err != nil {
+			return err
+		}
+	}
+	t.compiled = root
+	return nil
+}
+
+// Render validates vars against the declared variables and renders the
+// template. Few-shot examples come first as user/assistant pairs, followed by
+// the template's messages
+func (t *PromptTemplate) Render(vars map[string]any) (*SparkChatRequest, error) {
+	// Templates built in code may be rendered concurrently before anyone compiled them
+	t.once.Do(func() {
+		if t.compiled == nil {
+			t.compileErr = t.Compile()
+		}
+	})
+	if t.compileErr != nil {
+		return nil, newRequestError("invalid prompt template", t.compileErr)
+	}
+	data, err := t.bind(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	exec := func(name string) (string, error) {
+		var buf bytes.Buffer
+		if err := t.compiled.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", newRequestError(fmt.Sprintf("failed to render prompt %s", t.Name), err)
+		}
+		return buf.String(), nil
+	}
+
+	req := &SparkChatRequest{
+		Temperature: t.Temperature,
+		TopK:        t.TopK,
+		MaxTokens:   t.MaxTokens,
+	}
+	if req.System, err = exec("system"); err != nil {
+		return nil, err
+	}
+	for i := range t.Examples {
+		user, err := exec(fmt.Sprintf("example.%d.user", i))
+		if err != nil {
+			return nil, err
+		}
+		assistant, err := exec(fmt.Sprintf("example.%d.assistant", i))
+		if err != nil {
+			return nil, err
+		}
+		req.Messages = append(req.Messages,
+			SparkMessage{Role: "user", Content: user},
+			SparkMessage{Role: "assistant", Content: assistant})
+	}
+	for i, msg := range t.Messages {
+		content, err := exec(fmt.Sprintf("message.%d", i))
+		if err != nil {
+			return nil, err
+		}
+		req.Messages = append(req.Messages, SparkMessage{Role: msg.Role, Content: content})
+	}
+	return req, nil
+}
+
+// bind checks vars against the declarations and fills in defaults. Templates
+// without declarations accept any variables
+func (t *PromptTemplate) bind(vars map[string]any) (map[string]any, error) {
+	data := make(map[string]any, len(vars))
+	for k, v := range vars {
+		data[k] = v
+	}
+	if len(t.Variables) == 0 {
+		return data, nil
+	}
+
+	declared := map[string]bool{}
+	for _, decl := range t.Variables {
+		declared[decl.Name] = true
+		value, ok := data[decl.Name]
+		if !ok {
+			if decl.Required {
+				return nil, newRequestError(fmt.Sprintf("prompt %s: missing required variable %q", t.Name, decl.Name), nil)
+			}
+			data[decl.Name] = decl.defaultValue()
+			continue
+		}
+		if !promptTypeMatches(decl.Type, value) {
+			return nil, newRequestError(fmt.Sprintf("prompt %s: variable %q must be of type %s, got %T", t.Name, decl.Name, decl.typ(), value), nil)
+		}
+	}
+	for name := range data {
+		if !declared[name] {
+			return nil, newRequestError(fmt.Sprintf("prompt %s: undeclared variable %q", t.Name, name), nil)
+		}
+	}
+	return data, nil
+}
+
+// defaultValue returns the variable's default, or the zero value of its type so
+// that templates never render "<no value>"
+func (v PromptVariable) defaultValue() any {
+	if v.Default != nil {
+		return v.Default
+	}
+	switch v.typ() {
+	case PromptInt:
+		return 0
+	case PromptNumber:
+		return 0.0
+	case PromptBool:
+		return false
+	case PromptList:
+		return []any{}
+	}
+	return ""
+}
+
+func (v PromptVariable) typ() string {
+	if v.Type == "" {
+		return PromptString
+	}
+	return v.Type
+}
+
+// promptTypeMatches reports whether value is acceptable for a variable of typ
+func promptTypeMatches(typ string, value any) bool {
+	if value == nil {
+		return false
+	}
+	kind := reflect.TypeOf(value).Kind()
+	switch typ {
+	case "", PromptString:
+		return kind == reflect.String
+	case PromptInt:
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+		return false
+	case PromptNumber:
+		return promptTypeMatches(PromptInt, value) || kind == reflect.Float32 || kind == reflect.Float64
+	case PromptBool:
+		return kind == reflect.Bool
+	case PromptList:
+		return kind == reflect.Slice || kind == reflect.Array
+	}
+	return false
+}
+
+// PromptRegistry holds versioned prompt templates loaded from a file system
+// laid out as <name>/<version>.json
+type PromptRegistry struct {
+	fsys fs.FS
+
+	mu        sync.RWMutex
+	templates map[string][]*PromptTemplate // sorted by ascending version
+}
+
+// LoadPromptDir loads the templates stored under dir
+func LoadPromptDir(dir string) (*PromptRegistry, error) {
+	return NewPromptRegistry(os.DirFS(dir))
+}
+
+// NewPromptRegistry loads the templates stored in fsys, which may be an embed.FS
+func NewPromptRegistry(fsys fs.FS) (*PromptRegistry, error) {
+	r := &PromptRegistry{fsys: fsys}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads all templates. On error the previously loaded templates are kept
+func (r *PromptRegistry) Reload() error {
+	files, err := fs.Glob(r.fsys, "*/*.json")
+	if err != nil {
+		return err
+	}
+
+	templates := map[string][]*PromptTemplate{}
+	for _, file := range files {
+		data, err := fs.ReadFile(r.fsys, file)
+		if err != nil {
+			return err
+		}
+		t, err := ParsePromptTemplate(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		name, version := path.Dir(file), strings.TrimSuffix(path.Base(file), ".json")
+		if t.Name != "" && t.Name != name || t.Version != "" && t.Version != version {
+			return fmt.Errorf("%s: declares %s@%s, which does not match its path", file, t.Name, t.Version)
+		}
+		t.Name, t.Version = name, version
+		templates[name] = append(templates[name], t)
+	}
+	for _, versions := range templates {
+		sort.Slice(versions, func(i, j int) bool {
+			return compareVersions(versions[i].Version, versions[j].Version) < 0
+		})
+	}
+
+	r.mu.Lock()
+	r.templates = templates
+	r.mu.Unlock()
+	return nil
+}
+
+// Get returns the named template at version, or its latest version when version is ""
+func (r *PromptRegistry) Get(name, version string) (*PromptTemplate, error) {
+	r.mu.RLock()
+	versions := r.templates[name]
+	r.mu.RUnlock()
+
+	if len(versions) == 0 {
+		return nil, newRequestError(fmt.Sprintf("prompt %q not found", name), nil)
+	}
+	if version == "" {
+		return versions[len(versions)-1], nil
+	}
+	for _, t := range versions {
+		if t.Version == version {
+			return t, nil
+		}
+	}
+	return nil, newRequestError(fmt.Sprintf("prompt %q has no version %q", name, version), nil)
+}
+
+// Versions returns the versions of the named template, oldest first
+func (r *PromptRegistry) Versions(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]string, 0, len(r.templates[name]))
+	for _, t := range r.templates[name] {
+		out = append(out, t.Version)
+	}
+	return out
+}
+
+// Render renders the named template at version, or its latest version when version is ""
+func (r *PromptRegistry) Render(name, version string, vars map[string]any) (*SparkChatRequest, error) {
+	t, err := r.Get(name, version)
+	if err != nil {
+		return nil, err
+	}
+	return t.Render(vars)
+}
+
+// compareVersions orders versions such as "v2" < "v10" and "1.2" < "1.10",
+// comparing dot-separated parts numerically where both are numbers
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case pa[i] != pb[i]:
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(pa) - len(pb)
+}
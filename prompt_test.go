@@ -0,0 +1,137 @@
+package gosparkclient
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+const summarizeV1 = `{
+	"variables": [{"name": "text", "required": true}],
+	"messages": [{"role": "user", "content": "总结：{{.text}}"}]
+}`
+
+const summarizeV10 = `{
+	"variables": [
+		{"name": "text", "required": true},
+		{"name": "lang", "default": "中文"},
+		{"name": "points", "type": "int", "default": 3}
+	],
+	"system": "用{{.lang}}回答，最多 {{.points}} 条要点",
+	"examples": [{"user": "总结：{{.lang}}示例", "assistant": "- 示例"}],
+	"messages": [{"role": "user", "content": "总结：{{.text}}"}],
+	"temperature": 0.2
+}`
+
+func TestPromptRegistry(t *testing.T) {
+	registry, err := NewPromptRegistry(fstest.MapFS{
+		"summarize/v1.json":  {Data: []byte(summarizeV1)},
+		"summarize/v10.json": {Data: []byte(summarizeV10)},
+		"summarize/v2.json":  {Data: []byte(summarizeV1)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load prompts: %v", err)
+	}
+
+	if got, want := registry.Versions("summarize"), []string{"v1", "v2", "v10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Versions = %v, want %v", got, want)
+	}
+
+	req, err := registry.Render("summarize", "", map[string]any{"text": "长文", "points": 5})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	want := &SparkChatRequest{
+		System:      "用中文回答，最多 5 条要点",
+		Temperature: 0.2,
+		Messages: []SparkMessage{
+			{Role: "user", Content: "总结：中文示例"},
+			{Role: "assistant", Content: "- 示例"},
+			{Role: "user", Content: "总结：长文"},
+		},
+	}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("Render = %+v, want %+v", req, want)
+	}
+
+	req, err = registry.Render("summarize", "v1", map[string]any{"text": "长文"})
+	if err != nil {
+		t.Fatalf("Render v1 failed: %v", err)
+	}
+	if len(req.Messages) != 1 || req.System != "" {
+		t.Errorf("unexpected v1 request: %+v", req)
+	}
+
+	if _, err := registry.Get("summarize", "v3"); err == nil {
+		t.Error("expected error for unknown version")
+	}
+	if _, err := registry.Get("translate", ""); err == nil {
+		t.Error("expected error for unknown prompt")
+	}
+}
+
+func TestPromptTemplate_Validation(t *testing.T) {
+	tmpl, err := ParsePromptTemplate([]byte(summarizeV10))
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		vars map[string]any
+	}{
+		{"missing required", map[string]any{"lang": "英文"}},
+		{"wrong type", map[string]any{"text": "x", "points": "three"}},
+		{"undeclared", map[string]any{"text": "x", "tone": "formal"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tmpl.Render(tt.vars)
+			var sparkErr *SparkError
+			if !errors.As(err, &sparkErr) || sparkErr.Type != ErrRequest {
+				t.Errorf("expected request error, got %v", err)
+			}
+		})
+	}
+
+	if _, err := ParsePromptTemplate([]byte(`{"messages": [{"role": "user", "content": "{{.text"}]}`)); err == nil {
+		t.Error("expected parse error for malformed template")
+	}
+	if _, err := NewPromptRegistry(fstest.MapFS{
+		"a/v1.json": {Data: []byte(`{"name": "b", "messages": []}`)},
+	}); err == nil {
+		t.Error("expected error for name not matching path")
+	}
+}
+
+func TestPromptTemplate_OptionalWithoutDefault(t *testing.T) {
+	tmpl := &PromptTemplate{
+		Name: "greet",
+		Variables: []PromptVariable{
+			{Name: "name", Required: true},
+			{Name: "tone"},
+			{Name: "count", Type: PromptInt},
+		},
+		Messages: []SparkMessage{{Role: "user", Content: "{{.name}} tone={{.tone}} count={{.count}}"}},
+	}
+
+	// Templates built in code compile on first render, possibly concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := tmpl.Render(map[string]any{"name": "x"})
+			if err != nil {
+				t.Errorf("Render failed: %v", err)
+				return
+			}
+			if got := req.Messages[0].Content; got != "x tone= count=0" {
+				t.Errorf("content = %q, want %q", got, "x tone= count=0")
+			}
+		}()
+	}
+	wg.Wait()
+}
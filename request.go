@@ -0,0 +1,62 @@
+package gosparkclient
+
+// RequestOption overrides the client's configuration for a single call
+type RequestOption func(*Config)
+
+// WithRequestDomain sets the domain of a single call
+func WithRequestDomain(domain string) RequestOption {
+	return func(c *Config) {
+		c.Domain = domain
+	}
+}
+
+// WithRequestHostURL sets the chat endpoint of a single call
+func WithRequestHostURL(hostURL string) RequestOption {
+	return func(c *Config) {
+		c.HostURL = hostURL
+	}
+}
+
+// WithRequestModel sets the chat endpoint and domain of a single call
+func WithRequestModel(target ModelTarget) RequestOption {
+	return func(c *Config) {
+		c.HostURL = target.HostURL
+		c.Domain = target.Domain
+	}
+}
+
+// WithRequestUID sets the end-user UID of a single call
+func WithRequestUID(uid string) RequestOption {
+	return func(c *Config) {
+		c.UID = uid
+	}
+}
+
+// WithRequestAuditing sets the auditing mode of a single call
+func WithRequestAuditing(auditing string) RequestOption {
+	return func(c *Config) {
+		c.Auditing = auditing
+	}
+}
+
+// withRequestOptions returns a client for a single call with opts applied to a
+// copy of the config. Unlike WithNewConfig it shares the transport, middleware
+// and hooks of c. The configured patches belong to the configured model, so
+// they are dropped when opts switch models without setting patches of their own
+func (c *SparkClient) withRequestOptions(opts []RequestOption) (*SparkClient, error) {
+	if len(opts) == 0 {
+		return c, nil
+	}
+	config := *c.config
+	config.PatchIDs = nil
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.PatchIDs == nil && config.HostURL == c.config.HostURL && config.Domain == c.config.Domain {
+		config.PatchIDs = c.config.PatchIDs
+	}
+	if err := validateFineTune(config.Domain, config.PatchIDs); err != nil {
+		return nil, newRequestError("invalid fine-tuned model", err)
+	}
+	return &SparkClient{config: &config, transport: c.transport, logger: c.logger}, nil
+}
@@ -0,0 +1,73 @@
+package gosparkclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSparkClient_RequestOptions(t *testing.T) {
+	requests := make(chan SparkAPIRequest, 2)
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req SparkAPIRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		requests <- req
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]}}}`))
+	})
+	defer server.Close()
+
+	var calls []Call
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs("ws://unused", ""),
+		WithDomain("lite"),
+		WithMiddleware(func(next Handler) Handler {
+			return func(ctx context.Context, call *Call) (*Result, error) {
+				calls = append(calls, *call)
+				return next(ctx, call)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	resp, err := client.ChatSimple(context.Background(), "hi",
+		WithRequestModel(ModelTarget{HostURL: wsURL(server), Domain: "4.0Ultra"}),
+		WithRequestUID("user-42"),
+		WithRequestAuditing("strict"),
+	)
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	req := <-requests
+	if req.Parameter.Chat.Domain != "4.0Ultra" || req.Header.UID != "user-42" || req.Parameter.Chat.Auditing != "strict" {
+		t.Errorf("overrides not applied: %+v", req)
+	}
+	if resp.Model.Domain != "4.0Ultra" {
+		t.Errorf("response model = %+v", resp.Model)
+	}
+	if len(calls) != 1 || calls[0].Model.Domain != "4.0Ultra" {
+		t.Errorf("middleware did not see the overridden model: %+v", calls)
+	}
+
+	// The client itself is unchanged
+	if client.config.Domain != "lite" || client.config.UID != defaultUID {
+		t.Errorf("client config was modified: %+v", client.config)
+	}
+
+	scoped, err := client.withRequestOptions([]RequestOption{WithRequestDomain("max-32k")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scoped.transport != client.transport {
+		t.Error("per-request client must share the transport")
+	}
+	if same, _ := client.withRequestOptions(nil); same != client {
+		t.Error("expected the client itself without options")
+	}
+}
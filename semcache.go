@@ -0,0 +1,354 @@
+package gosparkclient
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultSemanticThreshold is the cosine similarity a question needs to reuse a cached answer
+const DefaultSemanticThreshold = 0.92
+
+// EmbedFunc embeds text into a vector
+type EmbedFunc func(ctx context.Context, text string) ([]float32, error)
+
+// SemanticEntry is a cached answer together with the question it answers
+type SemanticEntry struct {
+	HostURL  string
+	Domain   string
+	PatchIDs []string
+	System   string
+	Question string
+	Vector   []float32
+	Created  time.Time
+
+	scope string
+	chat  cachedChat
+}
+
+// Response returns the cached answer
+func (e *SemanticEntry) Response() *SparkAPIResponse {
+	return replayChat(&Call{}, e.chat).Response
+}
+
+// SemanticCacheStats counts the lookups of a SemanticCache
+type SemanticCacheStats struct {
+	Hits    int64
+	Misses  int64
+	Entries int
+}
+
+// SemanticCache answers single-turn chat calls with the cached answer of the
+// most similar previous question. Questions are compared by the cosine
+// similarity of their Spark embeddings, and only with questions asked of the
+// same model and patches with the same system prompt, max tokens, web search
+// and response format
+type SemanticCache struct {
+	embed      EmbedFunc
+	domain     string
+	threshold  float64
+	thresholds map[string]float64
+	ttl        time.Duration
+	maxEntries int
+	onError    func(error)
+	now        func() time.Time
+
+	mu      sync.RWMutex
+	entries []*SemanticEntry // oldest first
+	hits    int64
+	misses  int64
+}
+
+// SemanticCacheOption configures a SemanticCache
+type SemanticCacheOption func(*SemanticCache)
+
+// WithSemanticThreshold sets the similarity required for a hit, DefaultSemanticThreshold by default
+func WithSemanticThreshold(threshold float64) SemanticCacheOption {
+	return func(s *SemanticCache) {
+		s.threshold = threshold
+	}
+}
+
+// WithDomainThreshold sets the similarity required for a hit on domain
+func WithDomainThreshold(domain string, threshold float64) SemanticCacheOption {
+	return func(s *SemanticCache) {
+		s.thresholds[domain] = threshold
+	}
+}
+
+// WithSemanticTTL sets how long answers stay cached. Zero, the default, keeps them until evicted
+func WithSemanticTTL(ttl time.Duration) SemanticCacheOption {
+	return func(s *SemanticCache) {
+		s.ttl = ttl
+	}
+}
+
+// WithSemanticMaxEntries limits the number of cached answers, evicting the oldest first
+func WithSemanticMaxEntries(n int) SemanticCacheOption {
+	return func(s *SemanticCache) {
+		s.maxEntries = n
+	}
+}
+
+// WithEmbedFunc replaces the Spark embedding of questions
+func WithEmbedFunc(embed EmbedFunc) SemanticCacheOption {
+	return func(s *SemanticCache) {
+		s.embed = embed
+	}
+}
+
+// WithEmbeddingDomain sets the Spark embedding domain used for questions, "query" by default
+func WithEmbeddingDomain(domain string) SemanticCacheOption {
+	return func(s *SemanticCache) {
+		s.domain = domain
+	}
+}
+
+// WithSemanticErrorHandler sets a function called when a question cannot be embedded.
+// Such calls are sent upstream uncached
+func WithSemanticErrorHandler(handler func(error)) SemanticCacheOption {
+	return func(s *SemanticCache) {
+		s.onError = handler
+	}
+}
+
+// NewSemanticCache creates an empty SemanticCache
+func NewSemanticCache(opts ...SemanticCacheOption) *SemanticCache {
+	s := &SemanticCache{
+		domain:     "query",
+		threshold:  DefaultSemanticThreshold,
+		thresholds: map[string]float64{},
+		maxEntries: 10000,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Middleware returns middleware that serves similar questions from the cache.
+// Unless WithEmbedFunc is given, questions are embedded through the rest of
+// the chain, so the embedding calls are logged, metered and billed like any other
+func (s *SemanticCache) Middleware() Middleware {
+	return func(next Handler) Handler {
+		embed := s.embed
+		if embed == nil {
+			embed = func(ctx context.Context, text string) ([]float32, error) {
+				result, err := next(ctx, &Call{Kind: CallEmbedding, Query: text, EmbeddingDomain: s.domain})
+				if err != nil {
+					return nil, err
+				}
+				vector, err := result.Embedding.Vector()
+				if err != nil {
+					return nil, newResponseError("invalid embedding", err)
+				}
+				return vector, nil
+			}
+		}
+
+		return func(ctx context.Context, call *Call) (*Result, error) {
+			question, ok := singleTurnQuestion(call)
+			if !ok {
+				return next(ctx, call)
+			}
+
+			vector, err := embed(ctx, question)
+			if err != nil {
+				if s.onError != nil {
+					s.onError(err)
+				}
+				return next(ctx, call)
+			}
+
+			scope := semanticScope(call)
+			if entry := s.lookup(call.Model.Domain, scope, vector); entry != nil {
+				return replayChat(call, entry.chat), nil
+			}
+
+			var frames []string
+			onFrame := call.OnFrame
+			call.OnFrame = func(resp *SparkAPIResponse) {
+				content := ""
+				if len(resp.Payload.Choices.Text) > 0 {
+					content = resp.Payload.Choices.Text[0].Content
+				}
+				frames = append(frames, content)
+				if onFrame != nil {
+					onFrame(resp)
+				}
+			}
+
+			result, err := next(ctx, call)
+			if err != nil || result == nil || result.Response == nil {
+				return result, err
+			}
+			s.add(&SemanticEntry{
+				HostURL:  call.Model.HostURL,
+				Domain:   call.Model.Domain,
+				PatchIDs: call.PatchIDs,
+				System:   call.Request.System,
+				Question: question,
+				Vector:   vector,
+				scope:    scope,
+				chat:     newCachedChat(frames, result.Response),
+			})
+			return result, nil
+		}
+	}
+}
+
+// singleTurnQuestion returns the question of a chat call without history
+func singleTurnQuestion(call *Call) (string, bool) {
+	if call.Kind == CallEmbedding || call.Request == nil || call.Request.Functions != nil {
+		return "", false
+	}
+	var question string
+	users := 0
+	for _, msg := range call.Request.Messages {
+		switch msg.Role {
+		case "system":
+		case "user":
+			question = msg.Content
+			users++
+		default:
+			return "", false
+		}
+	}
+	return question, users == 1 && question != ""
+}
+
+// semanticScope identifies the calls that may share answers: those to the same
+// model and patches with the same system prompt and answer-shaping parameters
+func semanticScope(call *Call) string {
+	data, _ := json.Marshal(struct {
+		HostURL        string            `json:"host_url"`
+		Domain         string            `json:"domain"`
+		PatchIDs       []string          `json:"patch_ids,omitempty"`
+		System         string            `json:"system"`
+		MaxTokens      int               `json:"max_tokens"`
+		WebSearch      *WebSearchOptions `json:"web_search,omitempty"`
+		ResponseFormat *ResponseFormat   `json:"response_format,omitempty"`
+	}{
+		HostURL:        call.Model.HostURL,
+		Domain:         call.Model.Domain,
+		PatchIDs:       call.PatchIDs,
+		System:         call.Request.System,
+		MaxTokens:      call.Request.MaxTokens,
+		WebSearch:      call.Request.WebSearch,
+		ResponseFormat: call.Request.ResponseFormat,
+	})
+	return string(data)
+}
+
+// lookup returns the live entry of scope most similar to vector, if it passes the domain's threshold
+func (s *SemanticCache) lookup(domain, scope string, vector []float32) *SemanticEntry {
+	threshold, ok := s.thresholds[domain]
+	if !ok {
+		threshold = s.threshold
+	}
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best *SemanticEntry
+	bestScore := threshold
+	for _, e := range s.entries {
+		if e.scope != scope || s.expired(e, now) {
+			continue
+		}
+		if score := cosineSimilarity(vector, e.Vector); score >= bestScore {
+			best, bestScore = e, score
+		}
+	}
+	if best != nil {
+		s.hits++
+	} else {
+		s.misses++
+	}
+	return best
+}
+
+// add stores entry, dropping expired entries and evicting the oldest beyond the limit
+func (s *SemanticCache) add(entry *SemanticEntry) {
+	entry.Created = s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live := s.entries[:0]
+	for _, e := range s.entries {
+		if !s.expired(e, entry.Created) {
+			live = append(live, e)
+		}
+	}
+	s.entries = append(live, entry)
+	if s.maxEntries > 0 && len(s.entries) > s.maxEntries {
+		s.entries = append([]*SemanticEntry(nil), s.entries[len(s.entries)-s.maxEntries:]...)
+	}
+}
+
+func (s *SemanticCache) expired(e *SemanticEntry, now time.Time) bool {
+	return s.ttl > 0 && !now.Before(e.Created.Add(s.ttl))
+}
+
+// Invalidate removes the entries for which match returns true and returns how many were removed
+func (s *SemanticCache) Invalidate(match func(e *SemanticEntry) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.entries[:0]
+	for _, e := range s.entries {
+		if !match(e) {
+			kept = append(kept, e)
+		}
+	}
+	removed := len(s.entries) - len(kept)
+	for i := len(kept); i < len(s.entries); i++ {
+		s.entries[i] = nil
+	}
+	s.entries = kept
+	return removed
+}
+
+// InvalidateDomain removes the entries of domain
+func (s *SemanticCache) InvalidateDomain(domain string) int {
+	return s.Invalidate(func(e *SemanticEntry) bool { return e.Domain == domain })
+}
+
+// InvalidateQuestion removes the entries answering exactly question
+func (s *SemanticCache) InvalidateQuestion(question string) int {
+	return s.Invalidate(func(e *SemanticEntry) bool { return e.Question == question })
+}
+
+// Clear removes all entries
+func (s *SemanticCache) Clear() {
+	s.Invalidate(func(*SemanticEntry) bool { return true })
+}
+
+// Stats returns the hit and miss counts and the number of cached entries
+func (s *SemanticCache) Stats() SemanticCacheStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return SemanticCacheStats{Hits: s.hits, Misses: s.misses, Entries: len(s.entries)}
+}
+
+// cosineSimilarity returns the cosine similarity of a and b, or 0 when their lengths differ
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
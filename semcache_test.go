@@ -0,0 +1,194 @@
+package gosparkclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// encodeVector encodes v the way the Spark embedding API does
+func encodeVector(v []float32) string {
+	data := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(f))
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestSparkAPIEmbResponse_Vector(t *testing.T) {
+	var resp SparkAPIEmbResponse
+	resp.Payload.Feature.Text = encodeVector([]float32{0.5, -1, 2})
+	got, err := resp.Vector()
+	if err != nil {
+		t.Fatalf("Vector failed: %v", err)
+	}
+	if len(got) != 3 || got[0] != 0.5 || got[1] != -1 || got[2] != 2 {
+		t.Errorf("Vector = %v", got)
+	}
+
+	resp.Payload.Feature.Text = base64.StdEncoding.EncodeToString([]byte{1, 2, 3})
+	if _, err := resp.Vector(); err == nil {
+		t.Error("expected error for truncated vector")
+	}
+}
+
+func TestSemanticCache(t *testing.T) {
+	// Questions about refunds embed close to each other, anything else is orthogonal
+	embedding := func(text string) []float32 {
+		switch {
+		case strings.Contains(text, "退款"):
+			return []float32{1, 0.05 * float32(len(text)%3), 0}
+		default:
+			return []float32{0, 0, 1}
+		}
+	}
+
+	var chats, embeds atomic.Int32
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		var req map[string]json.RawMessage
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		if strings.Contains(string(req["parameter"]), `"emb"`) {
+			embeds.Add(1)
+			var emb SparkAPIEmbRequest
+			json.Unmarshal(mustMarshal(t, req), &emb)
+			conn.WriteJSON(map[string]any{
+				"header":  map[string]any{"code": 0},
+				"payload": map[string]any{"feature": map[string]any{"text": encodeVector(embedding(emb.Payload.Message.Text))}},
+			})
+			return
+		}
+		chats.Add(1)
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"7 天内可退款"}]}}}`))
+	})
+	defer server.Close()
+
+	cache := NewSemanticCache(WithDomainThreshold("lite", 0.99))
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), wsURL(server)),
+		WithSemanticCache(cache),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx := context.Background()
+	ask := func(question string) string {
+		t.Helper()
+		resp, err := client.ChatSimple(ctx, question)
+		if err != nil {
+			t.Fatalf("Chat failed: %v", err)
+		}
+		return resp.Payload.Choices.Text[0].Content
+	}
+
+	ask("怎么退款")
+	if got := ask("如何申请退款？"); got != "7 天内可退款" {
+		t.Errorf("unexpected cached answer %q", got)
+	}
+	ask("今天天气如何")
+
+	if n := chats.Load(); n != 2 {
+		t.Errorf("expected 2 upstream chats, got %d", n)
+	}
+	if n := embeds.Load(); n != 3 {
+		t.Errorf("expected 3 embeddings, got %d", n)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if n := cache.InvalidateQuestion("怎么退款"); n != 1 {
+		t.Errorf("InvalidateQuestion removed %d entries, want 1", n)
+	}
+	ask("如何申请退款？")
+	if n := chats.Load(); n != 3 {
+		t.Errorf("expected invalidated question to go upstream, got %d chats", n)
+	}
+
+	// History is never answered from the cache
+	_, err = client.Chat(ctx, &SparkChatRequest{Messages: []SparkMessage{
+		{Role: "user", Content: "怎么退款"},
+		{Role: "assistant", Content: "7 天内可退款"},
+		{Role: "user", Content: "如何申请退款？"},
+	}})
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if n := chats.Load(); n != 4 {
+		t.Errorf("expected multi-turn chat to go upstream, got %d chats", n)
+	}
+}
+
+func TestSemanticCache_DomainThreshold(t *testing.T) {
+	scope := func(domain string) string {
+		return semanticScope(&Call{Model: ModelTarget{Domain: domain}, Request: &SparkChatRequest{}})
+	}
+	cache := NewSemanticCache(WithDomainThreshold("lite", 0.999))
+	cache.add(&SemanticEntry{Domain: "lite", Question: "a", Vector: []float32{1, 0}, scope: scope("lite"), chat: cachedChat{Response: &SparkAPIResponse{}}})
+	cache.add(&SemanticEntry{Domain: "max-32k", Question: "a", Vector: []float32{1, 0}, scope: scope("max-32k"), chat: cachedChat{Response: &SparkAPIResponse{}}})
+
+	if cache.lookup("lite", scope("lite"), []float32{1, 0.1}) != nil {
+		t.Error("expected miss below the lite threshold")
+	}
+	if cache.lookup("max-32k", scope("max-32k"), []float32{1, 0.1}) == nil {
+		t.Error("expected hit above the default threshold")
+	}
+	if n := cache.InvalidateDomain("lite"); n != 1 {
+		t.Errorf("InvalidateDomain removed %d entries, want 1", n)
+	}
+}
+
+func TestSemanticCache_Scope(t *testing.T) {
+	base := func() *Call {
+		return &Call{
+			Kind:    CallChat,
+			Model:   ModelTarget{HostURL: AssistantURL("a"), Domain: "generalv3.5"},
+			Request: &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: "你是谁"}}},
+		}
+	}
+	cache := NewSemanticCache()
+	cache.add(&SemanticEntry{Question: "你是谁", Vector: []float32{1, 0}, scope: semanticScope(base()), chat: cachedChat{Response: &SparkAPIResponse{}}})
+	if cache.lookup("generalv3.5", semanticScope(base()), []float32{1, 0}) == nil {
+		t.Fatal("expected hit for the same scope")
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Call)
+	}{
+		{"host URL", func(c *Call) { c.Model.HostURL = AssistantURL("b") }},
+		{"patch IDs", func(c *Call) { c.PatchIDs = []string{"res-1"} }},
+		{"system prompt", func(c *Call) { c.Request.System = "另一个系统提示" }},
+		{"max tokens", func(c *Call) { c.Request.MaxTokens = 16 }},
+		{"web search", func(c *Call) { c.Request.WebSearch = &WebSearchOptions{Enable: true} }},
+		{"response format", func(c *Call) { c.Request.ResponseFormat = &ResponseFormat{Type: ResponseFormatJSON} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := base()
+			tt.change(call)
+			if cache.lookup("generalv3.5", semanticScope(call), []float32{1, 0}) != nil {
+				t.Errorf("calls differing by %s share an answer", tt.name)
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
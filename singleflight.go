@@ -0,0 +1,161 @@
+package gosparkclient
+
+import (
+	"context"
+	"sync"
+)
+
+// flight is an upstream call shared by every identical call made while it runs
+type flight struct {
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	frames  []*SparkAPIResponse
+	updated chan struct{} // closed and replaced whenever a frame arrives or the call ends
+	done    bool
+	waiters int
+
+	result *Result
+	err    error
+}
+
+// broadcast keeps a frame for the waiters, which deliver it on their own goroutines
+func (f *flight) broadcast(resp *SparkAPIResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.frames = append(f.frames, resp)
+	close(f.updated)
+	f.updated = make(chan struct{})
+}
+
+// finish records the outcome of the upstream call
+func (f *flight) finish(result *Result, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.result, f.err = result, err
+	f.done = true
+	close(f.updated)
+}
+
+// leave unregisters a waiter that gave up and reports whether it was the last one
+func (f *flight) leave() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.waiters--
+	return f.waiters == 0
+}
+
+// wait delivers the flight's frames to onFrame, starting with those sent
+// before the waiter joined, until the upstream call ends. Callbacks run
+// without holding any lock. It returns false if ctx ends first
+func (f *flight) wait(ctx context.Context, onFrame ChatCallback) bool {
+	delivered := 0
+	for {
+		f.mu.Lock()
+		frames := f.frames[delivered:]
+		updated, done := f.updated, f.done
+		f.mu.Unlock()
+
+		for _, resp := range frames {
+			if onFrame != nil {
+				frame := *resp
+				onFrame(&frame)
+			}
+		}
+		delivered += len(frames)
+		if len(frames) > 0 {
+			continue
+		}
+		if done {
+			return true
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// share returns a copy of the flight's result for one waiter
+func (f *flight) share() (*Result, error) {
+	if f.err != nil || f.result == nil {
+		return f.result, f.err
+	}
+	result := *f.result
+	if result.Response != nil {
+		resp := *result.Response
+		resp.Payload.Choices.Text = append([]SparkChoice(nil), resp.Payload.Choices.Text...)
+		result.Response = &resp
+	}
+	return &result, nil
+}
+
+// SingleflightMiddleware returns middleware that collapses identical calls
+// made while one of them is in flight into a single upstream call. Streamed
+// frames are delivered to every waiting ChatWithCallback, including frames
+// sent before it joined. Each waiter's callback runs on the waiter's own
+// goroutine, so a slow callback delays neither the upstream call nor other waiters.
+//
+// Calls are identical when they have the same CacheKey and tenant. The shared
+// call keeps running while at least one waiter is interested in it. Register
+// it before WithUsageLedger to record the shared call's usage only once
+func SingleflightMiddleware() Middleware {
+	var mu sync.Mutex
+	flights := map[string]*flight{}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*Result, error) {
+			key := TenantFromContext(ctx) + "\x00" + CacheKey(call)
+
+			mu.Lock()
+			f, ok := flights[key]
+			if !ok {
+				sharedCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+				f = &flight{cancel: cancel, updated: make(chan struct{})}
+				flights[key] = f
+
+				shared := *call
+				if shared.Kind != CallEmbedding {
+					shared.OnFrame = f.broadcast
+				}
+				go func() {
+					defer cancel()
+					result, err := next(sharedCtx, &shared)
+
+					mu.Lock()
+					if flights[key] == f {
+						delete(flights, key)
+					}
+					mu.Unlock()
+
+					f.finish(result, err)
+				}()
+			}
+			f.mu.Lock()
+			f.waiters++
+			f.mu.Unlock()
+			mu.Unlock()
+
+			if f.wait(ctx, call.OnFrame) {
+				return f.share()
+			}
+
+			// The last waiter to leave cancels the call, and later identical
+			// calls must not join it
+			mu.Lock()
+			if f.leave() {
+				if flights[key] == f {
+					delete(flights, key)
+				}
+				f.cancel()
+			}
+			mu.Unlock()
+			return nil, newRequestError("request cancelled", ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSparkClient_Singleflight(t *testing.T) {
+	var dials atomic.Int32
+	release := make(chan struct{})
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		dials.Add(1)
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":0,"text":[{"content":"你"}]}}}`))
+		<-release
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"好"}]}}}`))
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(
+		WithCredentials("app", "key", "secret"),
+		WithURLs(wsURL(server), ""),
+		WithSingleflight(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx := context.Background()
+	req := &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: "你好"}}}
+
+	const streams = 3
+	var wg sync.WaitGroup
+	answers := make([]string, streams)
+	firstFrames := make(chan struct{}, streams)
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := client.ChatWithCallback(ctx, req, func(resp *SparkAPIResponse) {
+				answers[i] += resp.Payload.Choices.Text[0].Content
+				if resp.Payload.Choices.Status == 0 {
+					firstFrames <- struct{}{}
+				}
+			})
+			if err != nil {
+				t.Errorf("ChatWithCallback failed: %v", err)
+			}
+		}(i)
+	}
+	for i := 0; i < streams; i++ {
+		<-firstFrames
+	}
+
+	var chatResp *SparkAPIResponse
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var err error
+		if chatResp, err = client.Chat(ctx, req); err != nil {
+			t.Errorf("Chat failed: %v", err)
+		}
+	}()
+
+	// A waiter that gives up does not cancel the shared call
+	cancelled, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err = client.Chat(cancelled, req)
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrRequest {
+		t.Errorf("expected request error for cancelled waiter, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if n := dials.Load(); n != 1 {
+		t.Errorf("expected 1 upstream call, got %d", n)
+	}
+	for i, answer := range answers {
+		if answer != "你好" {
+			t.Errorf("stream %d got %q, want 你好", i, answer)
+		}
+	}
+	if chatResp == nil || chatResp.Payload.Choices.Text[0].Content != "你好" {
+		t.Errorf("unexpected Chat response %+v", chatResp)
+	}
+
+	// Once the call is done, identical calls go upstream again
+	if _, err := client.Chat(ctx, req); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if n := dials.Load(); n != 2 {
+		t.Errorf("expected a new upstream call, got %d", n)
+	}
+}
+
+func TestSingleflight_DistinctCalls(t *testing.T) {
+	var calls atomic.Int32
+	handler := SingleflightMiddleware()(func(ctx context.Context, call *Call) (*Result, error) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return &Result{Response: &SparkAPIResponse{}}, nil
+	})
+
+	var wg sync.WaitGroup
+	for _, tenant := range []string{"a", "b"} {
+		for _, question := range []string{"x", "y"} {
+			wg.Add(1)
+			go func(tenant, question string) {
+				defer wg.Done()
+				handler(WithTenant(context.Background(), tenant), &Call{
+					Kind:    CallChat,
+					Request: &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: strings.Repeat(question, 2)}}},
+				})
+			}(tenant, question)
+		}
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 4 {
+		t.Errorf("expected 4 distinct upstream calls, got %d", n)
+	}
+}
+
+func TestSingleflight_CancelledFlightIsNotJoined(t *testing.T) {
+	var calls atomic.Int32
+	handler := SingleflightMiddleware()(func(ctx context.Context, call *Call) (*Result, error) {
+		if calls.Add(1) == 1 {
+			<-ctx.Done()
+			// The cancelled upstream call takes a while to unwind
+			time.Sleep(50 * time.Millisecond)
+			return nil, ctx.Err()
+		}
+		return &Result{Response: &SparkAPIResponse{}}, nil
+	})
+	call := func() *Call {
+		return &Call{Kind: CallChat, Request: &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: "x"}}}}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := handler(ctx, call()); err == nil {
+		t.Fatal("expected the cancelled call to fail")
+	}
+
+	if _, err := handler(context.Background(), call()); err != nil {
+		t.Fatalf("call after the last waiter left failed: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected a new upstream call, got %d", n)
+	}
+}
+
+func TestSingleflight_SlowCallback(t *testing.T) {
+	proceed := make(chan struct{})
+	handler := SingleflightMiddleware()(func(ctx context.Context, call *Call) (*Result, error) {
+		if call.Request.Messages[0].Content == "shared" {
+			<-proceed
+			call.OnFrame(&SparkAPIResponse{})
+			call.OnFrame(&SparkAPIResponse{})
+		}
+		return &Result{Response: &SparkAPIResponse{}}, nil
+	})
+	call := func(question string, onFrame ChatCallback) *Call {
+		return &Call{
+			Kind:    CallChatStream,
+			Request: &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: question}}},
+			OnFrame: onFrame,
+		}
+	}
+
+	unblock := make(chan struct{})
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(slowDone)
+		handler(context.Background(), call("shared", func(*SparkAPIResponse) { <-unblock }))
+	}()
+
+	var frames atomic.Int32
+	fastDone := make(chan error, 1)
+	go func() {
+		_, err := handler(context.Background(), call("shared", func(*SparkAPIResponse) { frames.Add(1) }))
+		fastDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(proceed)
+
+	// Neither another waiter of the same call nor an unrelated call waits for the slow callback
+	select {
+	case err := <-fastDone:
+		if err != nil || frames.Load() != 2 {
+			t.Errorf("fast waiter got %d frames, err %v", frames.Load(), err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fast waiter blocked by a slow callback")
+	}
+	other := make(chan struct{})
+	go func() {
+		handler(context.Background(), call("other", nil))
+		close(other)
+	}()
+	select {
+	case <-other:
+	case <-time.After(time.Second):
+		t.Fatal("unrelated call blocked by a slow callback")
+	}
+
+	close(unblock)
+	<-slowDone
+}
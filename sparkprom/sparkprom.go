@@ -0,0 +1,126 @@
+// Package sparkprom exports gosparkclient request metrics to Prometheus.
+// It lives in its own package so that the core client does not depend on Prometheus
+package sparkprom
+
+import (
+	"github.com/fruitbars/gosparkclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a gosparkclient.MetricsHook that records Prometheus metrics.
+// Register it with a prometheus.Registerer and pass it to gosparkclient.WithMetrics
+type Collector struct {
+	requests         *prometheus.CounterVec
+	dialDuration     *prometheus.HistogramVec
+	timeToFirstFrame *prometheus.HistogramVec
+	duration         *prometheus.HistogramVec
+	frames           *prometheus.CounterVec
+	tokens           *prometheus.CounterVec
+}
+
+// Option configures a Collector
+type Option func(*options)
+
+type options struct {
+	namespace string
+	buckets   []float64
+}
+
+// WithNamespace sets the metric namespace, "spark" by default
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithBuckets sets the histogram buckets in seconds
+func WithBuckets(buckets []float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// NewCollector creates a Collector
+func NewCollector(opts ...Option) *Collector {
+	o := &options{
+		namespace: "spark",
+		buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	histogram := func(name, help string, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Name:      name,
+			Help:      help,
+			Buckets:   o.buckets,
+		}, labels)
+	}
+
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "requests_total",
+			Help:      "Spark requests by call kind, domain and error type (empty on success).",
+		}, []string{"kind", "domain", "error_type"}),
+		dialDuration:     histogram("dial_duration_seconds", "Time to sign and open the WebSocket connection.", "domain"),
+		timeToFirstFrame: histogram("time_to_first_frame_seconds", "Time from sending the request to the first response frame.", "domain"),
+		duration:         histogram("request_duration_seconds", "Total request latency.", "kind", "domain"),
+		frames: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "frames_total",
+			Help:      "Response frames received.",
+		}, []string{"domain"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "tokens_total",
+			Help:      "Tokens reported in SparkUsage, by type (question, prompt, completion).",
+		}, []string{"domain", "type"}),
+	}
+}
+
+// ObserveRequest implements gosparkclient.MetricsHook
+func (c *Collector) ObserveRequest(m gosparkclient.RequestMetrics) {
+	c.requests.WithLabelValues(string(m.Kind), m.Domain, string(m.ErrorType)).Inc()
+	c.duration.WithLabelValues(string(m.Kind), m.Domain).Observe(m.Duration.Seconds())
+	if m.DialDuration > 0 {
+		c.dialDuration.WithLabelValues(m.Domain).Observe(m.DialDuration.Seconds())
+	}
+	if m.Frames > 0 {
+		c.timeToFirstFrame.WithLabelValues(m.Domain).Observe(m.TimeToFirstFrame.Seconds())
+		c.frames.WithLabelValues(m.Domain).Add(float64(m.Frames))
+	}
+
+	usage := m.Usage
+	if usage.QuestionTokens > 0 {
+		c.tokens.WithLabelValues(m.Domain, "question").Add(float64(usage.QuestionTokens))
+	}
+	if usage.PromptTokens > 0 {
+		c.tokens.WithLabelValues(m.Domain, "prompt").Add(float64(usage.PromptTokens))
+	}
+	if usage.CompletionTokens > 0 {
+		c.tokens.WithLabelValues(m.Domain, "completion").Add(float64(usage.CompletionTokens))
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.dialDuration.Describe(ch)
+	c.timeToFirstFrame.Describe(ch)
+	c.duration.Describe(ch)
+	c.frames.Describe(ch)
+	c.tokens.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.dialDuration.Collect(ch)
+	c.timeToFirstFrame.Collect(ch)
+	c.duration.Collect(ch)
+	c.frames.Collect(ch)
+	c.tokens.Collect(ch)
+}
@@ -0,0 +1,60 @@
+package sparkprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fruitbars/gosparkclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_ObserveRequest(t *testing.T) {
+	collector := NewCollector()
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	collector.ObserveRequest(gosparkclient.RequestMetrics{
+		Kind:             gosparkclient.CallChat,
+		Domain:           "lite",
+		DialDuration:     20 * time.Millisecond,
+		TimeToFirstFrame: 300 * time.Millisecond,
+		Duration:         time.Second,
+		Frames:           3,
+		Usage:            gosparkclient.SparkUsage{QuestionTokens: 4, PromptTokens: 10, CompletionTokens: 25, TotalTokens: 35},
+	})
+	collector.ObserveRequest(gosparkclient.RequestMetrics{
+		Kind:         gosparkclient.CallChat,
+		Domain:       "lite",
+		ErrorType:    gosparkclient.ErrResponse,
+		ErrorCode:    10110,
+		DialDuration: 10 * time.Millisecond,
+		Duration:     50 * time.Millisecond,
+	})
+
+	if got := testutil.ToFloat64(collector.requests.WithLabelValues("chat", "lite", "")); got != 1 {
+		t.Errorf("successful requests = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(collector.requests.WithLabelValues("chat", "lite", "ResponseError")); got != 1 {
+		t.Errorf("failed requests = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(collector.frames.WithLabelValues("lite")); got != 3 {
+		t.Errorf("frames = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(collector.tokens.WithLabelValues("lite", "completion")); got != 25 {
+		t.Errorf("completion tokens = %v, want 25", got)
+	}
+	if got := testutil.ToFloat64(collector.tokens.WithLabelValues("lite", "question")); got != 4 {
+		t.Errorf("question tokens = %v, want 4", got)
+	}
+
+	// The failed request had no frames, so only one time-to-first-frame sample exists
+	if got := testutil.CollectAndCount(collector.timeToFirstFrame); got != 1 {
+		t.Errorf("time to first frame series = %d, want 1", got)
+	}
+	if got := testutil.CollectAndCount(collector.dialDuration); got != 1 {
+		t.Errorf("dial duration series = %d, want 1", got)
+	}
+}
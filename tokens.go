@@ -0,0 +1,188 @@
+package gosparkclient
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"unicode"
+)
+
+// calibrationWeight is how strongly each observed response moves the estimator's scale
+const calibrationWeight = 0.2
+
+// DefaultContextWindows holds the context window, in tokens, of the public Spark domains
+var DefaultContextWindows = map[string]int{
+	"lite":        4096,
+	"generalv3":   8192,
+	"pro-128k":    131072,
+	"generalv3.5": 8192,
+	"max-32k":     32768,
+	"4.0Ultra":    8192,
+}
+
+// PreflightMode controls what Chat does when a request does not fit the model's context window
+type PreflightMode int
+
+const (
+	// PreflightOff sends requests unchecked
+	PreflightOff PreflightMode = iota
+	// PreflightError fails requests that do not fit before they are sent
+	PreflightError
+	// PreflightTruncate drops the oldest history messages until the request fits
+	PreflightTruncate
+)
+
+// TokenEstimator estimates token counts locally. It starts from iFlytek's rule
+// of thumb and calibrates itself against the prompt tokens reported by Spark
+type TokenEstimator struct {
+	mu      sync.RWMutex
+	scale   float64
+	samples int
+}
+
+// NewTokenEstimator creates an uncalibrated estimator
+func NewTokenEstimator() *TokenEstimator {
+	return &TokenEstimator{scale: 1}
+}
+
+// EstimateTokens estimates the prompt tokens of req with an uncalibrated estimator
+func EstimateTokens(req *SparkChatRequest) int {
+	return rawRequestTokens(req)
+}
+
+// EstimateText estimates the tokens of text
+func (e *TokenEstimator) EstimateText(text string) int {
+	return e.scaled(estimateTokens(text))
+}
+
+// EstimateTokens estimates the prompt tokens of req, including its system prompt
+func (e *TokenEstimator) EstimateTokens(req *SparkChatRequest) int {
+	return e.scaled(rawRequestTokens(req))
+}
+
+// Calibrate adjusts the estimator with the prompt tokens Spark reported for req
+func (e *TokenEstimator) Calibrate(req *SparkChatRequest, usage SparkUsage) {
+	raw := rawRequestTokens(req)
+	if raw == 0 || usage.PromptTokens == 0 {
+		return
+	}
+	ratio := float64(usage.PromptTokens) / float64(raw)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.samples == 0 {
+		e.scale = ratio
+	} else {
+		e.scale += calibrationWeight * (ratio - e.scale)
+	}
+	e.samples++
+}
+
+// Scale returns the calibration factor applied to the rule-of-thumb estimate
+func (e *TokenEstimator) Scale() float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.scale
+}
+
+func (e *TokenEstimator) scaled(raw int) int {
+	return int(math.Ceil(float64(raw) * e.Scale()))
+}
+
+// rawRequestTokens returns the uncalibrated token estimate of req
+func rawRequestTokens(req *SparkChatRequest) int {
+	if req == nil {
+		return 0
+	}
+	total := estimateTokens(req.System)
+	for _, msg := range req.Messages {
+		// Images are billed separately from their base64 encoding
+		if msg.ContentType == ContentTypeImage {
+			continue
+		}
+		total += estimateTokens(msg.Content)
+	}
+	return total
+}
+
+// estimateTokens roughly estimates the tokens of text using iFlytek's rule of
+// thumb: one token is about 1.5 Chinese characters or 0.8 English words
+func estimateTokens(text string) int {
+	var cjk, words int
+	inWord := false
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			cjk++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				words++
+				inWord = true
+			}
+		default:
+			inWord = false
+		}
+	}
+	return (cjk*2+2)/3 + (words*5+3)/4
+}
+
+// estimator returns the client's token estimator
+func (c *SparkClient) estimator() *TokenEstimator {
+	return c.config.Estimator
+}
+
+// preflight checks that req fits the context window of domain, reserving
+// MaxTokens for the answer. In truncate mode it returns a copy of req with the
+// oldest messages removed, always keeping the last one and a leading image
+func (c *SparkClient) preflight(req *SparkChatRequest, domain string) (*SparkChatRequest, error) {
+	if c.config.Preflight == PreflightOff || req == nil {
+		return req, nil
+	}
+	window, ok := c.contextWindow(domain)
+	if !ok {
+		return req, nil
+	}
+
+	estimator := c.estimator()
+	limit := window - req.MaxTokens
+	estimate := estimator.EstimateTokens(req)
+	if estimate <= limit {
+		return req, nil
+	}
+
+	if c.config.Preflight == PreflightTruncate {
+		// Image understanding requires the image at the head of the conversation
+		var pinned []SparkMessage
+		history := req.Messages
+		if len(history) > 0 && history[0].ContentType == ContentTypeImage {
+			pinned, history = history[:1], history[1:]
+		}
+
+		truncated := *req
+		for len(history) > 1 {
+			history = history[1:]
+			// Never start the history with an assistant reply
+			for len(history) > 1 && history[0].Role == "assistant" {
+				history = history[1:]
+			}
+			truncated.Messages = append(append([]SparkMessage(nil), pinned...), history...)
+			if estimator.EstimateTokens(&truncated) <= limit {
+				return &truncated, nil
+			}
+		}
+	}
+
+	return nil, newRequestError(fmt.Sprintf(
+		"request of about %d tokens does not fit the %d token context window of %s with %d tokens reserved for the answer",
+		estimate, window, domain, req.MaxTokens), nil)
+}
+
+// contextWindow returns the context window of domain
+func (c *SparkClient) contextWindow(domain string) (int, bool) {
+	if window, ok := c.config.ContextWindows[domain]; ok {
+		return window, true
+	}
+	window, ok := DefaultContextWindows[domain]
+	return window, ok
+}
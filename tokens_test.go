@@ -0,0 +1,126 @@
+package gosparkclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{text: "", want: 0},
+		{text: "你好世界", want: 3},
+		{text: "hello world, this is spark", want: 7},
+		{text: "用 Go 写一个 HTTP server", want: 7},
+	}
+	for _, tt := range tests {
+		if got := estimateTokens(tt.text); got != tt.want {
+			t.Errorf("estimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTokenEstimator_Calibrate(t *testing.T) {
+	estimator := NewTokenEstimator()
+	req := &SparkChatRequest{Messages: []SparkMessage{{Role: "user", Content: "hello world, this is spark"}}}
+
+	if got := estimator.EstimateTokens(req); got != 7 {
+		t.Fatalf("uncalibrated estimate = %d, want 7", got)
+	}
+
+	estimator.Calibrate(req, SparkUsage{PromptTokens: 14})
+	if got := estimator.EstimateTokens(req); got != 14 {
+		t.Errorf("estimate after first sample = %d, want 14", got)
+	}
+
+	// Later samples move the scale gradually
+	estimator.Calibrate(req, SparkUsage{PromptTokens: 7})
+	if scale := estimator.Scale(); scale <= 1 || scale >= 2 {
+		t.Errorf("scale = %v, want between 1 and 2", scale)
+	}
+}
+
+func TestSparkClient_Preflight(t *testing.T) {
+	var sent SparkAPIRequest
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if err := conn.ReadJSON(&sent); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]}}}`))
+	})
+	defer server.Close()
+
+	history := []SparkMessage{
+		{Role: "user", Content: "first question about something rather long and wordy"},
+		{Role: "assistant", Content: "first answer that is also rather long and wordy"},
+		{Role: "user", Content: "second question"},
+	}
+	newClient := func(mode PreflightMode) *SparkClient {
+		client, err := NewSparkClient(
+			WithCredentials("app", "key", "secret"),
+			WithURLs(wsURL(server), ""),
+			WithDomain("tiny"),
+			WithContextWindow("tiny", 10),
+			WithTokenEstimator(NewTokenEstimator()),
+			WithPreflight(mode),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		return client
+	}
+
+	_, err := newClient(PreflightError).Chat(context.Background(), &SparkChatRequest{Messages: history})
+	var sparkErr *SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Type != ErrRequest {
+		t.Fatalf("expected request error, got %v", err)
+	}
+
+	if _, err := newClient(PreflightTruncate).Chat(context.Background(), &SparkChatRequest{Messages: history}); err != nil {
+		t.Fatalf("Chat with truncation failed: %v", err)
+	}
+	if got := sent.Payload.Message.Text; len(got) != 1 || got[0].Content != "second question" {
+		t.Errorf("expected only the last question to be sent, got %+v", got)
+	}
+	if len(history) != 3 {
+		t.Error("caller's history must not be modified")
+	}
+}
+
+func TestSparkClient_CalibrationIsPerClient(t *testing.T) {
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"ok"}]},"usage":{"text":{"prompt_tokens":50}}}}`))
+	})
+	defer server.Close()
+
+	newClient := func() *SparkClient {
+		client, err := NewSparkClient(
+			WithCredentials("app", "key", "secret"),
+			WithURLs(wsURL(server), ""),
+			WithDomain("lite"),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		return client
+	}
+	busy, idle := newClient(), newClient()
+
+	if _, err := busy.ChatSimple(context.Background(), "hello world"); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if scale := busy.estimator().Scale(); scale == 1 {
+		t.Error("expected the client's estimator to be calibrated")
+	}
+	if scale := idle.estimator().Scale(); scale != 1 {
+		t.Errorf("other client's scale = %v, want 1", scale)
+	}
+}
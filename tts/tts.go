@@ -0,0 +1,482 @@
+// Package tts is a client for iFlytek's online speech synthesis WebSocket API.
+// It authenticates with the same credentials and hmac-sha256 URL signing as gosparkclient
+package tts
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fruitbars/gosparkclient"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultURL is the online speech synthesis endpoint
+const DefaultURL = "wss://tts-api.xfyun.cn/v2/tts"
+
+// MaxTextBytes is the longest text accepted by a single request, measured
+// after base64 encoding. That allows up to 6000 bytes of UTF-8 text
+const MaxTextBytes = 8000
+
+// Format is the encoding of the synthesized audio
+type Format string
+
+const (
+	// FormatPCM is raw 16-bit little-endian mono samples
+	FormatPCM Format = "pcm"
+	// FormatWAV is PCM with a RIFF header
+	FormatWAV Format = "wav"
+	// FormatMP3 is MP3 encoded audio
+	FormatMP3 Format = "mp3"
+)
+
+// Config holds the options of a Client. Voice and audio options may also be
+// given per call
+type Config struct {
+	AppID       string
+	URL         string
+	Timeout     time.Duration
+	Credentials gosparkclient.CredentialsProvider
+	Signer      gosparkclient.Signer
+
+	Voice      string
+	Speed      int
+	Volume     int
+	Pitch      int
+	Format     Format
+	SampleRate int
+}
+
+// Option sets a Config option
+type Option func(*Config)
+
+// DefaultConfig returns a Config with default values
+func DefaultConfig() *Config {
+	return &Config{
+		URL:        DefaultURL,
+		Timeout:    30 * time.Second,
+		Signer:     &gosparkclient.HMACSigner{},
+		Voice:      "xiaoyan",
+		Speed:      50,
+		Volume:     50,
+		Pitch:      50,
+		Format:     FormatPCM,
+		SampleRate: 16000,
+	}
+}
+
+// WithCredentials sets static credentials for the client
+func WithCredentials(appID, apiKey, apiSecret string) Option {
+	return func(c *Config) {
+		c.AppID = appID
+		c.Credentials = gosparkclient.StaticCredentials{AppID: appID, APIKey: apiKey, APISecret: apiSecret}
+	}
+}
+
+// WithCredentialsProvider sets the provider the client retrieves credentials from
+func WithCredentialsProvider(provider gosparkclient.CredentialsProvider) Option {
+	return func(c *Config) {
+		c.Credentials = provider
+	}
+}
+
+// WithSigner sets the signer used to authenticate request URLs
+func WithSigner(signer gosparkclient.Signer) Option {
+	return func(c *Config) {
+		c.Signer = signer
+	}
+}
+
+// WithURL sets the synthesis endpoint, DefaultURL by default
+func WithURL(url string) Option {
+	return func(c *Config) {
+		c.URL = url
+	}
+}
+
+// WithTimeout sets the handshake timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.Timeout = timeout
+	}
+}
+
+// WithVoice sets the speaker, "xiaoyan" by default
+func WithVoice(voice string) Option {
+	return func(c *Config) {
+		c.Voice = voice
+	}
+}
+
+// WithSpeed sets the speaking rate from 0 to 100, 50 by default
+func WithSpeed(speed int) Option {
+	return func(c *Config) {
+		c.Speed = speed
+	}
+}
+
+// WithVolume sets the volume from 0 to 100, 50 by default
+func WithVolume(volume int) Option {
+	return func(c *Config) {
+		c.Volume = volume
+	}
+}
+
+// WithPitch sets the pitch from 0 to 100, 50 by default
+func WithPitch(pitch int) Option {
+	return func(c *Config) {
+		c.Pitch = pitch
+	}
+}
+
+// WithFormat sets the audio format, FormatPCM by default
+func WithFormat(format Format) Option {
+	return func(c *Config) {
+		c.Format = format
+	}
+}
+
+// WithSampleRate sets the sample rate of PCM and WAV audio, 8000 or 16000 (the default)
+func WithSampleRate(rate int) Option {
+	return func(c *Config) {
+		c.SampleRate = rate
+	}
+}
+
+// validateConfig checks if the configuration is valid
+func validateConfig(c *Config) error {
+	if c.AppID == "" && c.Credentials == nil {
+		return errors.New("AppID is required")
+	}
+	if c.Credentials == nil {
+		return errors.New("credentials are required")
+	}
+	if c.URL == "" {
+		return errors.New("URL is required")
+	}
+	return validateAudio(c)
+}
+
+// validateAudio checks the voice and audio options
+func validateAudio(c *Config) error {
+	if c.Voice == "" {
+		return errors.New("voice is required")
+	}
+	for name, v := range map[string]int{"speed": c.Speed, "volume": c.Volume, "pitch": c.Pitch} {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("%s must be in [0, 100], got %d", name, v)
+		}
+	}
+	switch c.Format {
+	case FormatPCM, FormatWAV, FormatMP3:
+	default:
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+	if c.SampleRate != 8000 && c.SampleRate != 16000 {
+		return fmt.Errorf("sample rate must be 8000 or 16000, got %d", c.SampleRate)
+	}
+	return nil
+}
+
+// Client synthesizes speech over the online TTS WebSocket API
+type Client struct {
+	config *Config
+}
+
+// NewClient creates a Client with the given options
+func NewClient(opts ...Option) (*Client, error) {
+	config := DefaultConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+	if err := validateConfig(config); err != nil {
+		return nil, gosparkclient.NewSparkError(gosparkclient.ErrConfiguration, "invalid configuration", err)
+	}
+	return &Client{config: config}, nil
+}
+
+// Result describes a finished synthesis
+type Result struct {
+	SID        string
+	Format     Format
+	SampleRate int
+	// Bytes is the length of the audio received, excluding any WAV header
+	Bytes int64
+}
+
+// request is the single frame sent to the synthesis API
+type request struct {
+	Common struct {
+		AppID string `json:"app_id"`
+	} `json:"common"`
+	Business struct {
+		Aue    string `json:"aue"`
+		Sfl    int    `json:"sfl,omitempty"`
+		Auf    string `json:"auf"`
+		Vcn    string `json:"vcn"`
+		Speed  int    `json:"speed"`
+		Volume int    `json:"volume"`
+		Pitch  int    `json:"pitch"`
+		Tte    string `json:"tte"`
+	} `json:"business"`
+	Data struct {
+		Status int    `json:"status"`
+		Text   string `json:"text"`
+	} `json:"data"`
+}
+
+// response is a frame received from the synthesis API
+type response struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	SID     string `json:"sid"`
+	Data    *struct {
+		Audio  string `json:"audio"`
+		Status int    `json:"status"`
+	} `json:"data"`
+}
+
+// Stream synthesizes text and passes the audio to onChunk as it arrives.
+// Chunks are raw PCM for FormatPCM and FormatWAV and MP3 frames for
+// FormatMP3. Returning an error from onChunk stops the synthesis
+func (c *Client) Stream(ctx context.Context, text string, onChunk func(chunk []byte) error, opts ...Option) (_ *Result, err error) {
+	config := c.withOptions(opts)
+	if err := validateAudio(config); err != nil {
+		return nil, gosparkclient.NewSparkError(gosparkclient.ErrRequest, "invalid synthesis options", err)
+	}
+	if text == "" {
+		return nil, gosparkclient.NewSparkError(gosparkclient.ErrRequest, "text is required", nil)
+	}
+	if n := base64.StdEncoding.EncodedLen(len(text)); n > MaxTextBytes {
+		return nil, gosparkclient.NewSparkError(gosparkclient.ErrRequest,
+			fmt.Sprintf("text is %d bytes encoded, the limit is %d", n, MaxTextBytes), nil)
+	}
+
+	conn, creds, err := config.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer func() { config.reportCredentials(creds, err) }()
+
+	// Unblock a pending read as soon as the context is cancelled
+	stopClose := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopClose()
+
+	if err := conn.WriteJSON(config.request(creds.AppID, text)); err != nil {
+		return nil, gosparkclient.NewSparkError(gosparkclient.ErrRequest, "failed to send text", err)
+	}
+
+	result := &Result{Format: config.Format, SampleRate: config.SampleRate}
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, gosparkclient.NewSparkError(gosparkclient.ErrRequest, "request cancelled", ctx.Err())
+			}
+			return nil, gosparkclient.NewSparkError(gosparkclient.ErrWebSocket, "failed to read message", err)
+		}
+
+		var resp response
+		if err := json.Unmarshal(msg, &resp); err != nil {
+			return nil, gosparkclient.NewSparkError(gosparkclient.ErrResponse, "failed to parse response", err)
+		}
+		if resp.SID != "" {
+			result.SID = resp.SID
+		}
+		if resp.Code != 0 {
+			sparkErr := gosparkclient.NewSparkError(gosparkclient.ErrResponse, resp.Message, nil)
+			sparkErr.Code = resp.Code
+			return nil, sparkErr
+		}
+		if resp.Data == nil {
+			continue
+		}
+
+		audio, err := base64.StdEncoding.DecodeString(resp.Data.Audio)
+		if err != nil {
+			return nil, gosparkclient.NewSparkError(gosparkclient.ErrResponse, "failed to decode audio", err)
+		}
+		if len(audio) > 0 {
+			result.Bytes += int64(len(audio))
+			if err := onChunk(audio); err != nil {
+				return nil, err
+			}
+		}
+		if resp.Data.Status == 2 {
+			return result, nil
+		}
+	}
+}
+
+// Synthesize synthesizes text and writes the audio to w in the configured
+// format. WAV output is written as it arrives when w is an io.WriteSeeker,
+// so the header can be completed at the end, and is buffered otherwise. If
+// synthesis fails midway, a seekable w is left with a valid WAV file of the
+// audio received so far
+func (c *Client) Synthesize(ctx context.Context, text string, w io.Writer, opts ...Option) (*Result, error) {
+	config := c.withOptions(opts)
+	if config.Format != FormatWAV {
+		return c.Stream(ctx, text, writeChunk(w), opts...)
+	}
+
+	if ws, ok := w.(io.WriteSeeker); ok {
+		start, err := ws.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, writeError(err)
+		}
+		if _, err := ws.Write(wavHeader(config.SampleRate, 0)); err != nil {
+			return nil, writeError(err)
+		}
+		var written int64
+		write := writeChunk(ws)
+		result, err := c.Stream(ctx, text, func(chunk []byte) error {
+			if err := write(chunk); err != nil {
+				return err
+			}
+			written += int64(len(chunk))
+			return nil
+		}, opts...)
+		if err != nil {
+			// Keep the audio received so far playable
+			finishWAV(ws, start, config.SampleRate, written)
+			return nil, err
+		}
+		if err := finishWAV(ws, start, config.SampleRate, written); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	var pcm []byte
+	result, err := c.Stream(ctx, text, func(chunk []byte) error {
+		pcm = append(pcm, chunk...)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(wavHeader(config.SampleRate, result.Bytes)); err != nil {
+		return nil, writeError(err)
+	}
+	if _, err := w.Write(pcm); err != nil {
+		return nil, writeError(err)
+	}
+	return result, nil
+}
+
+// finishWAV rewrites the header at start for dataSize bytes of audio and
+// moves back to the end of ws
+func finishWAV(ws io.WriteSeeker, start int64, sampleRate int, dataSize int64) error {
+	if _, err := ws.Seek(start, io.SeekStart); err != nil {
+		return writeError(err)
+	}
+	if _, err := ws.Write(wavHeader(sampleRate, dataSize)); err != nil {
+		return writeError(err)
+	}
+	if _, err := ws.Seek(0, io.SeekEnd); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
+
+func writeChunk(w io.Writer) func([]byte) error {
+	return func(chunk []byte) error {
+		if _, err := w.Write(chunk); err != nil {
+			return writeError(err)
+		}
+		return nil
+	}
+}
+
+func writeError(err error) error {
+	return gosparkclient.NewSparkError(gosparkclient.ErrRequest, "failed to write audio", err)
+}
+
+// withOptions returns a copy of the client's config with opts applied
+func (c *Client) withOptions(opts []Option) *Config {
+	config := *c.config
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return &config
+}
+
+// request builds the synthesis request for text
+func (c *Config) request(appID, text string) *request {
+	req := &request{}
+	req.Common.AppID = appID
+	req.Business.Aue = "raw"
+	if c.Format == FormatMP3 {
+		req.Business.Aue = "lame"
+		req.Business.Sfl = 1
+	}
+	req.Business.Auf = fmt.Sprintf("audio/L16;rate=%d", c.SampleRate)
+	req.Business.Vcn = c.Voice
+	req.Business.Speed = c.Speed
+	req.Business.Volume = c.Volume
+	req.Business.Pitch = c.Pitch
+	req.Business.Tte = "UTF8"
+	req.Data.Status = 2
+	req.Data.Text = base64.StdEncoding.EncodeToString([]byte(text))
+	return req
+}
+
+// dial retrieves credentials, signs the endpoint URL and opens the WebSocket connection
+func (c *Config) dial(ctx context.Context) (*websocket.Conn, gosparkclient.Credentials, error) {
+	// A malformed URL is not the credentials' fault, so it is rejected before
+	// any are taken from the provider
+	if u, err := url.Parse(c.URL); err != nil || u.Host == "" {
+		return nil, gosparkclient.Credentials{}, gosparkclient.NewSparkError(gosparkclient.ErrRequest, fmt.Sprintf("invalid URL %q", c.URL), err)
+	}
+
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, gosparkclient.Credentials{}, gosparkclient.NewSparkError(gosparkclient.ErrAuthentication, "failed to retrieve credentials", err)
+	}
+	if creds.AppID == "" {
+		creds.AppID = c.AppID
+	}
+
+	signer := c.Signer
+	if signer == nil {
+		signer = &gosparkclient.HMACSigner{}
+	}
+	authURL, err := signer.Sign(ctx, http.MethodGet, c.URL, creds)
+	if err != nil {
+		sparkErr := gosparkclient.NewSparkError(gosparkclient.ErrAuthentication, "failed to sign request", err)
+		c.reportCredentials(creds, sparkErr)
+		return nil, gosparkclient.Credentials{}, sparkErr
+	}
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: c.Timeout,
+		Proxy:            http.ProxyFromEnvironment,
+	}
+	conn, resp, err := dialer.DialContext(ctx, authURL, nil)
+	if err != nil {
+		var sparkErr *gosparkclient.SparkError
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			sparkErr = gosparkclient.NewSparkError(gosparkclient.ErrAuthentication,
+				fmt.Sprintf("handshake rejected (HTTP %d)", resp.StatusCode), err)
+		} else {
+			sparkErr = gosparkclient.NewSparkError(gosparkclient.ErrConnection, "failed to establish WebSocket connection", err)
+		}
+		c.reportCredentials(creds, sparkErr)
+		return nil, gosparkclient.Credentials{}, sparkErr
+	}
+	return conn, creds, nil
+}
+
+// reportCredentials tells the credentials provider how a request signed with creds ended
+func (c *Config) reportCredentials(creds gosparkclient.Credentials, err error) {
+	if reporter, ok := c.Credentials.(gosparkclient.CredentialsReporter); ok {
+		reporter.ReportResult(creds, nil, err)
+	}
+}
@@ -0,0 +1,300 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fruitbars/gosparkclient"
+	"github.com/gorilla/websocket"
+)
+
+// newMockServer replies to every request with chunks as audio frames. The
+// text "违规" is answered with an error frame instead, and "中断" with an
+// error frame after the first chunk
+func newMockServer(t *testing.T, got *request, chunks ...string) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, param := range []string{"host", "date", "authorization"} {
+			if r.URL.Query().Get(param) == "" {
+				t.Errorf("missing signed parameter %q", param)
+			}
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		var req request
+		if err := conn.ReadJSON(&req); err != nil {
+			t.Errorf("read request: %v", err)
+			return
+		}
+		if got != nil {
+			*got = req
+		}
+		text, _ := base64.StdEncoding.DecodeString(req.Data.Text)
+		if string(text) == "违规" {
+			conn.WriteJSON(map[string]any{"code": 10160, "message": "text audit failed", "sid": "tts2"})
+			return
+		}
+		for i, chunk := range chunks {
+			if i == 1 && string(text) == "中断" {
+				conn.WriteJSON(map[string]any{"code": 10163, "message": "engine error", "sid": "tts3"})
+				return
+			}
+			status := 1
+			if i == len(chunks)-1 {
+				status = 2
+			}
+			conn.WriteJSON(map[string]any{
+				"code": 0,
+				"sid":  "tts1",
+				"data": map[string]any{"audio": base64.StdEncoding.EncodeToString([]byte(chunk)), "status": status},
+			})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, opts ...Option) *Client {
+	t.Helper()
+	opts = append([]Option{
+		WithCredentials("app", "key", "secret"),
+		WithURL("ws" + strings.TrimPrefix(server.URL, "http")),
+	}, opts...)
+	client, err := NewClient(opts...)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"valid", []Option{WithCredentials("app", "key", "secret")}, false},
+		{"missing credentials", nil, true},
+		{"speed out of range", []Option{WithCredentials("app", "key", "secret"), WithSpeed(101)}, true},
+		{"unknown format", []Option{WithCredentials("app", "key", "secret"), WithFormat("ogg")}, true},
+		{"unsupported sample rate", []Option{WithCredentials("app", "key", "secret"), WithSampleRate(44100)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var sparkErr *gosparkclient.SparkError
+			if err != nil && (!errors.As(err, &sparkErr) || sparkErr.Type != gosparkclient.ErrConfiguration) {
+				t.Errorf("error = %v, want configuration error", err)
+			}
+		})
+	}
+}
+
+func TestClient_Stream(t *testing.T) {
+	var got request
+	server := newMockServer(t, &got, "ab", "cd", "ef")
+	client := newTestClient(t, server, WithVoice("xiaofeng"), WithSpeed(60))
+
+	var chunks []string
+	result, err := client.Stream(context.Background(), "你好", func(chunk []byte) error {
+		chunks = append(chunks, string(chunk))
+		return nil
+	}, WithPitch(70))
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if strings.Join(chunks, "|") != "ab|cd|ef" {
+		t.Errorf("chunks = %v", chunks)
+	}
+	if result.SID != "tts1" || result.Bytes != 6 || result.Format != FormatPCM {
+		t.Errorf("result = %+v", result)
+	}
+
+	b := got.Business
+	if got.Common.AppID != "app" || b.Vcn != "xiaofeng" || b.Speed != 60 || b.Pitch != 70 || b.Volume != 50 {
+		t.Errorf("request = %+v", got)
+	}
+	if b.Aue != "raw" || b.Auf != "audio/L16;rate=16000" || b.Tte != "UTF8" {
+		t.Errorf("audio parameters = %+v", b)
+	}
+	if text, _ := base64.StdEncoding.DecodeString(got.Data.Text); string(text) != "你好" || got.Data.Status != 2 {
+		t.Errorf("data = %+v", got.Data)
+	}
+}
+
+func TestClient_StreamStopsOnCallbackError(t *testing.T) {
+	server := newMockServer(t, nil, "ab", "cd")
+	client := newTestClient(t, server)
+
+	stop := errors.New("stop")
+	calls := 0
+	_, err := client.Stream(context.Background(), "你好", func([]byte) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("err = %v after %d calls, want stop after 1", err, calls)
+	}
+}
+
+func TestClient_StreamErrors(t *testing.T) {
+	server := newMockServer(t, nil, "ab")
+	client := newTestClient(t, server)
+	discard := func([]byte) error { return nil }
+
+	_, err := client.Stream(context.Background(), "违规", discard)
+	var sparkErr *gosparkclient.SparkError
+	if !errors.As(err, &sparkErr) || sparkErr.Code != 10160 || sparkErr.Type != gosparkclient.ErrResponse {
+		t.Errorf("error = %v, want response error with code 10160", err)
+	}
+
+	// The limit applies to the base64-encoded text
+	if _, err := client.Stream(context.Background(), strings.Repeat("a", 6000), discard); err != nil {
+		t.Errorf("6000-byte text error = %v, want success", err)
+	}
+	_, err = client.Stream(context.Background(), strings.Repeat("a", 6001), discard)
+	if !errors.As(err, &sparkErr) || sparkErr.Type != gosparkclient.ErrRequest {
+		t.Errorf("6001-byte text error = %v, want request error", err)
+	}
+
+	_, err = client.Stream(context.Background(), "你好", discard, WithVolume(-1))
+	if !errors.As(err, &sparkErr) || sparkErr.Type != gosparkclient.ErrRequest {
+		t.Errorf("invalid option error = %v, want request error", err)
+	}
+}
+
+func TestClient_SynthesizeMP3(t *testing.T) {
+	var got request
+	server := newMockServer(t, &got, "ID3", "frame")
+	client := newTestClient(t, server)
+
+	var buf bytes.Buffer
+	if _, err := client.Synthesize(context.Background(), "你好", &buf, WithFormat(FormatMP3)); err != nil {
+		t.Fatalf("Synthesize() error = %v", err)
+	}
+	if buf.String() != "ID3frame" {
+		t.Errorf("audio = %q", buf.String())
+	}
+	if got.Business.Aue != "lame" || got.Business.Sfl != 1 {
+		t.Errorf("business = %+v, want lame with sfl", got.Business)
+	}
+}
+
+func TestClient_SynthesizeWAV(t *testing.T) {
+	server := newMockServer(t, nil, "\x01\x00\x02\x00", "\x03\x00")
+	client := newTestClient(t, server, WithFormat(FormatWAV), WithSampleRate(8000))
+
+	check := func(t *testing.T, wav []byte) {
+		t.Helper()
+		if len(wav) != 44+6 {
+			t.Fatalf("len = %d, want 50", len(wav))
+		}
+		if string(wav[0:4]) != "RIFF" || string(wav[8:12]) != "WAVE" || string(wav[36:40]) != "data" {
+			t.Errorf("header = %q", wav[:44])
+		}
+		if size := binary.LittleEndian.Uint32(wav[4:]); size != 42 {
+			t.Errorf("RIFF size = %d, want 42", size)
+		}
+		if rate := binary.LittleEndian.Uint32(wav[24:]); rate != 8000 {
+			t.Errorf("sample rate = %d, want 8000", rate)
+		}
+		if size := binary.LittleEndian.Uint32(wav[40:]); size != 6 {
+			t.Errorf("data size = %d, want 6", size)
+		}
+		if string(wav[44:]) != "\x01\x00\x02\x00\x03\x00" {
+			t.Errorf("samples = %v", wav[44:])
+		}
+	}
+
+	t.Run("buffered", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := client.Synthesize(context.Background(), "你好", &buf); err != nil {
+			t.Fatalf("Synthesize() error = %v", err)
+		}
+		check(t, buf.Bytes())
+	})
+
+	t.Run("seekable", func(t *testing.T) {
+		f, err := os.Create(filepath.Join(t.TempDir(), "out.wav"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := client.Synthesize(context.Background(), "你好", f); err != nil {
+			t.Fatalf("Synthesize() error = %v", err)
+		}
+		f.Close()
+		wav, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, wav)
+	})
+
+	t.Run("seekable failure", func(t *testing.T) {
+		f, err := os.Create(filepath.Join(t.TempDir(), "out.wav"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := client.Synthesize(context.Background(), "中断", f); err == nil {
+			t.Fatal("expected an error")
+		}
+		f.Close()
+		wav, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wav) != 44+4 || binary.LittleEndian.Uint32(wav[40:]) != 4 || binary.LittleEndian.Uint32(wav[4:]) != 40 {
+			t.Errorf("partial file has %d bytes and header %v, want a header for 4 bytes of audio", len(wav), wav[:44])
+		}
+	})
+}
+
+func TestClient_ReportsToCredentialPool(t *testing.T) {
+	server := newMockServer(t, nil, "ab")
+	pool, err := gosparkclient.NewCredentialPool([]gosparkclient.PoolMember{
+		{Credentials: gosparkclient.Credentials{AppID: "app", APIKey: "key", APISecret: "secret"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, server, WithCredentialsProvider(pool))
+	discard := func([]byte) error { return nil }
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Stream(context.Background(), "你好", discard); err != nil {
+			t.Fatalf("Stream() error = %v", err)
+		}
+	}
+	if _, err := client.Stream(context.Background(), "违规", discard); err == nil {
+		t.Fatal("expected an error")
+	}
+	// A refused connection is reported too
+	if _, err := client.Stream(context.Background(), "你好", discard, WithURL("ws://127.0.0.1:1/v2/tts")); err == nil {
+		t.Fatal("expected a connection error")
+	}
+
+	stats := pool.Stats()[0]
+	if stats.InFlight != 0 || stats.Requests != 5 || stats.Failures != 2 {
+		t.Errorf("stats = %+v, want 5 requests, 2 failures and none in flight", stats)
+	}
+}
@@ -0,0 +1,28 @@
+package tts
+
+import "encoding/binary"
+
+// wavHeader returns the 44 byte RIFF header of 16-bit mono PCM audio with dataSize bytes of samples
+func wavHeader(sampleRate int, dataSize int64) []byte {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+	)
+	blockAlign := channels * bitsPerSample / 8
+
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], uint32(36+dataSize))
+	copy(h[8:], "WAVE")
+	copy(h[12:], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(h[20:], 1)  // PCM
+	binary.LittleEndian.PutUint16(h[22:], channels)
+	binary.LittleEndian.PutUint32(h[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(h[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(h[34:], bitsPerSample)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], uint32(dataSize))
+	return h
+}
@@ -1,6 +1,7 @@
 package gosparkclient
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -9,39 +10,47 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"strings"
 	"time"
 )
 
-// assembleAuthURL generates the authenticated URL for API requests
-func (c *SparkClient) assembleAuthURL(httpMethod string, hostURL string) string {
-	ul, err := url.Parse(hostURL)
-	if err != nil {
-		panic(fmt.Sprintf("invalid URL: %v", err))
+// assembleAuthURL resolves the client's credentials and signs hostURL with them.
+// The resolved credentials are returned so the caller can fill in the request header
+func (c *SparkClient) assembleAuthURL(ctx context.Context, httpMethod string, hostURL string) (string, Credentials, error) {
+	// A malformed URL is not the credentials' fault, so it is rejected before
+	// any are taken from the provider
+	if u, err := url.Parse(hostURL); err != nil || u.Host == "" {
+		return "", Credentials{}, newRequestError(fmt.Sprintf("invalid host URL %q", hostURL), err)
 	}
 
-	date := time.Now().UTC().Format(time.RFC1123)
-	signString := []string{
-		"host: " + ul.Host,
-		"date: " + date,
-		httpMethod + " " + ul.Path + " HTTP/1.1",
+	creds, err := c.retrieveCredentials(ctx)
+	if err != nil {
+		return "", Credentials{}, err
 	}
 
-	signature := hmacSha256ToBase64(strings.Join(signString, "\n"), c.config.ApiSecret)
-	authorization := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(
-		"hmac username=\"%s\", algorithm=\"%s\", headers=\"%s\", signature=\"%s\"",
-		c.config.ApiKey,
-		"hmac-sha256",
-		"host date request-line",
-		signature,
-	)))
-
-	v := url.Values{}
-	v.Add("host", ul.Host)
-	v.Add("date", date)
-	v.Add("authorization", authorization)
+	signer := c.config.Signer
+	if signer == nil {
+		signer = &HMACSigner{}
+	}
+	authURL, err := signer.Sign(ctx, httpMethod, hostURL, creds)
+	if err != nil {
+		sparkErr := newAuthError("failed to sign request", err)
+		c.reportCredentials(creds, nil, sparkErr)
+		return "", Credentials{}, sparkErr
+	}
+	return authURL, creds, nil
+}
 
-	return hostURL + "?" + v.Encode()
+// retrieveCredentials returns the credentials for the next request, falling
+// back to the configured AppID when the provider does not set one
+func (c *SparkClient) retrieveCredentials(ctx context.Context) (Credentials, error) {
+	creds, err := c.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return Credentials{}, newAuthError("failed to retrieve credentials", err)
+	}
+	if creds.AppID == "" {
+		creds.AppID = c.config.AppID
+	}
+	return creds, nil
 }
 
 // hmacSha256ToBase64 generates an HMAC-SHA256 signature and returns it as base64
@@ -0,0 +1,86 @@
+package gosparkclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Web search modes
+const (
+	SearchModeNormal = "normal"
+	SearchModeDeep   = "deep"
+)
+
+// searchPluginName is the plugin that reports web search results
+const searchPluginName = "ifly_search"
+
+// WebSearchOptions configures the web_search tool of Spark 4.0 Ultra and Max
+type WebSearchOptions struct {
+	Enable bool `json:"enable"`
+	// ShowRefLabel asks for search results to be returned as citations
+	ShowRefLabel bool   `json:"show_ref_label,omitempty"`
+	SearchMode   string `json:"search_mode,omitempty"`
+}
+
+// SparkTool is an entry of the tools chat parameter
+type SparkTool struct {
+	Type      string            `json:"type"`
+	WebSearch *WebSearchOptions `json:"web_search,omitempty"`
+}
+
+// Citation is a web page the answer is based on
+type Citation struct {
+	Index int    `json:"index"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// ParseCitations returns the web search results carried by a frame's plugins
+func ParseCitations(plugins *SparkPlugins) ([]Citation, error) {
+	if plugins == nil {
+		return nil, nil
+	}
+	var citations []Citation
+	for _, p := range plugins.Text {
+		if p.Name != searchPluginName {
+			continue
+		}
+		results, err := decodeSearchResults(p)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s results: %w", p.Name, err)
+		}
+		citations = append(citations, results...)
+	}
+	return citations, nil
+}
+
+// decodeSearchResults decodes the content of a web search plugin result
+func decodeSearchResults(result PluginResult) ([]Citation, error) {
+	if result.Content == "" {
+		return nil, nil
+	}
+	var citations []Citation
+	if err := json.Unmarshal([]byte(result.Content), &citations); err != nil {
+		return nil, err
+	}
+	return citations, nil
+}
+
+// mergeCitations adds the citations in add to list, keeping it ordered by index without duplicates
+func mergeCitations(list, add []Citation) []Citation {
+	for _, c := range add {
+		dup := false
+		for _, existing := range list {
+			if existing.Index == c.Index && existing.URL == c.URL {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			list = append(list, c)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Index < list[j].Index })
+	return list
+}
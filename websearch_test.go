@@ -0,0 +1,106 @@
+package gosparkclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSparkClient_WebSearch(t *testing.T) {
+	var sent SparkAPIRequest
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if err := conn.ReadJSON(&sent); err != nil {
+			return
+		}
+		frames := []string{
+			`{"header":{"code":0},"payload":{"choices":{"status":0,"text":[{"content":""}]},"plugins":{"text":[{"name":"ifly_search","content":"[{\"index\":2,\"url\":\"https://b.example\",\"title\":\"B\"},{\"index\":1,\"url\":\"https://a.example\",\"title\":\"A\"}]"}]}}}`,
+			`{"header":{"code":0},"payload":{"choices":{"status":1,"text":[{"content":"据报道[1]"}]}}}`,
+			`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"。"}]}}}`,
+		}
+		for _, frame := range frames {
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(WithCredentials("app", "key", "secret"), WithURLs(wsURL(server), ""))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	resp, err := client.Chat(context.Background(), &SparkChatRequest{
+		Messages:  []SparkMessage{{Role: "user", Content: "今天的新闻"}},
+		WebSearch: &WebSearchOptions{Enable: true, ShowRefLabel: true, SearchMode: SearchModeDeep},
+	})
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	wantTools := []SparkTool{{Type: "web_search", WebSearch: &WebSearchOptions{Enable: true, ShowRefLabel: true, SearchMode: SearchModeDeep}}}
+	if !reflect.DeepEqual(sent.Parameter.Chat.Tools, wantTools) {
+		t.Errorf("tools = %+v, want %+v", sent.Parameter.Chat.Tools, wantTools)
+	}
+	wantCitations := []Citation{
+		{Index: 1, Title: "A", URL: "https://a.example"},
+		{Index: 2, Title: "B", URL: "https://b.example"},
+	}
+	if !reflect.DeepEqual(resp.Citations, wantCitations) {
+		t.Errorf("citations = %+v, want %+v", resp.Citations, wantCitations)
+	}
+	if got := resp.Payload.Choices.Text[0].Content; got != "据报道[1]。" {
+		t.Errorf("content = %q", got)
+	}
+}
+
+func TestSparkClient_WebSearchDisabled(t *testing.T) {
+	req := &SparkChatRequest{WebSearch: &WebSearchOptions{Enable: false}}
+	client := &SparkClient{config: DefaultConfig()}
+	apiReq := client.genReqJson(req, "4.0Ultra", "app")
+	if len(apiReq.Parameter.Chat.Tools) != 1 || apiReq.Parameter.Chat.Tools[0].WebSearch.Enable {
+		t.Errorf("expected web search to be explicitly disabled, got %+v", apiReq.Parameter.Chat.Tools)
+	}
+	if tools := client.genReqJson(&SparkChatRequest{}, "4.0Ultra", "app").Parameter.Chat.Tools; tools != nil {
+		t.Errorf("expected no tools by default, got %+v", tools)
+	}
+}
+
+func TestSparkClient_WebSearchAcrossFrames(t *testing.T) {
+	server := newMockServerFunc(t, func(conn *websocket.Conn) {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		frames := []string{
+			`{"header":{"code":0},"payload":{"choices":{"status":0,"text":[{"content":""}]},"plugins":{"text":[{"name":"ifly_search","invoked":{"plugin_id":"s1"},"status":"running","content":"[{\"index\":1,\"url\":\"https://a.example\",\"title\":\"A\"}]"}]}}}`,
+			`{"header":{"code":0},"payload":{"choices":{"status":1,"text":[{"content":"据报道"}]},"plugins":{"text":[{"name":"ifly_search","invoked":{"plugin_id":"s1"},"status":"running","content":"not json"}]}}}`,
+			`{"header":{"code":0},"payload":{"choices":{"status":1,"text":[{"content":"[1][2]"}]},"plugins":{"text":[{"name":"ifly_search","invoked":{"plugin_id":"s1"},"status":"done","content":"[{\"index\":2,\"url\":\"https://b.example\",\"title\":\"B\"}]"}]}}}`,
+			`{"header":{"code":0},"payload":{"choices":{"status":2,"text":[{"content":"。"}]}}}`,
+		}
+		for _, frame := range frames {
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+	})
+	defer server.Close()
+
+	client, err := NewSparkClient(WithCredentials("app", "key", "secret"), WithURLs(wsURL(server), ""))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	resp, err := client.Chat(context.Background(), &SparkChatRequest{
+		Messages:  []SparkMessage{{Role: "user", Content: "今天的新闻"}},
+		WebSearch: &WebSearchOptions{Enable: true, ShowRefLabel: true},
+	})
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	// A malformed frame drops only its own results
+	want := []Citation{
+		{Index: 1, Title: "A", URL: "https://a.example"},
+		{Index: 2, Title: "B", URL: "https://b.example"},
+	}
+	if !reflect.DeepEqual(resp.Citations, want) {
+		t.Errorf("citations = %+v, want %+v", resp.Citations, want)
+	}
+}